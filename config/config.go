@@ -1,30 +1,232 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
 	Port        string
-	DBPath      string
+	DBDriver    string // "postgres" | "sqlite"
+	DBPath      string // database file used by the sqlite driver
 	AIAPIKey    string
 	AIAPIURL    string
 	Environment string
 	LogLevel    string
+
+	// AutoMigrate applies pending migrations when the server starts;
+	// otherwise they are applied with the migrate command
+	AutoMigrate bool
+
+	// ContextTokenBudget is the number of prompt tokens sent to the AI service
+	ContextTokenBudget int
+	// MaxTokensLimit is the highest max tokens a session may request
+	MaxTokensLimit int
+
+	// Retry policy for failed AI provider calls
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+
+	// Circuit breaker around each AI provider
+	BreakerFailureThreshold int
+	BreakerOpenTimeout      time.Duration
+
+	// DefaultSessionOwner is the user (ID or email) that sessions created
+	// before user accounts existed are assigned to
+	DefaultSessionOwner string
+
+	// Rate limits per user (or client IP): requests per minute and how many
+	// may be made at once. Chat limits apply to the routes calling the AI.
+	ChatRateLimit int
+	ChatRateBurst int
+	ReadRateLimit int
+	ReadRateBurst int
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// whose X-Forwarded-For header gives the client IP. None are trusted by
+	// default, so clients cannot pick the IP they are rate limited by.
+	TrustedProxies []string
+
+	// Authentication tokens
+	JWTSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// AdminEmails are the users given the admin role at startup
+	AdminEmails []string
+
+	// Monthly quotas per plan; users without a plan are on DefaultPlan.
+	// A warning is sent once usage passes QuotaWarningPercent of a limit.
+	QuotaPlans          map[string]QuotaPlan
+	DefaultPlan         string
+	QuotaWarningPercent int
+
+	// Providers are the AI backends that sessions and requests can choose from
+	Providers       []ProviderConfig
+	DefaultProvider string
+	// FallbackProviders are tried in order when the chosen provider fails
+	FallbackProviders []string
+	// ModelPrices are used to compute the cost of each response
+	ModelPrices map[string]ModelPrice
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// QuotaPlan is the monthly token and cost (USD) limit of a plan.
+// A zero limit is unlimited.
+type QuotaPlan struct {
+	TokenLimit int64
+	CostLimit  float64
+}
+
+// ProviderConfig describes an AI backend
+type ProviderConfig struct {
+	Name   string
+	Type   string // "openai" | "anthropic" | "ollama" | "mock"
+	URL    string
+	APIKey string
+	Models []string // the first model is the default
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
-	return &Config{
+	cfg := &Config{
 		Port:        getEnv("PORT", "8080"),
+		DBDriver:    getEnv("DB_DRIVER", "postgres"),
 		DBPath:      getEnv("DB_PATH", "chatbot.db"),
 		AIAPIKey:    getEnv("AI_API_KEY", ""),
 		AIAPIURL:    getEnv("AI_API_URL", "https://api.openai.com/v1/chat/completions"),
 		Environment: getEnv("ENVIRONMENT", "development"),
 		LogLevel:    getEnv("LOG_LEVEL", "info"),
+
+		AutoMigrate: getEnvAsBool("DB_AUTO_MIGRATE", true),
+
+		ContextTokenBudget: getEnvAsInt("AI_CONTEXT_TOKENS", 3000),
+		MaxTokensLimit:     getEnvAsInt("AI_MAX_TOKENS_LIMIT", 4096),
+
+		RetryMaxAttempts: getEnvAsInt("AI_RETRY_MAX_ATTEMPTS", 3),
+		RetryBaseDelay:   getEnvAsMillis("AI_RETRY_BASE_DELAY_MS", 500),
+		RetryMaxDelay:    getEnvAsMillis("AI_RETRY_MAX_DELAY_MS", 8000),
+
+		BreakerFailureThreshold: getEnvAsInt("AI_BREAKER_FAILURES", 5),
+		BreakerOpenTimeout:      getEnvAsMillis("AI_BREAKER_OPEN_MS", 30000),
+
+		DefaultSessionOwner: getEnv("DEFAULT_SESSION_OWNER", ""),
+
+		ChatRateLimit: getEnvAsInt("RATE_LIMIT_CHAT_PER_MINUTE", 20),
+		ChatRateBurst: getEnvAsInt("RATE_LIMIT_CHAT_BURST", 5),
+		ReadRateLimit: getEnvAsInt("RATE_LIMIT_READ_PER_MINUTE", 300),
+		ReadRateBurst: getEnvAsInt("RATE_LIMIT_READ_BURST", 60),
+
+		JWTSecret:       getEnv("JWT_SECRET", ""),
+		AccessTokenTTL:  time.Duration(getEnvAsInt("JWT_ACCESS_TTL_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL: time.Duration(getEnvAsInt("JWT_REFRESH_TTL_HOURS", 720)) * time.Hour,
+
+		DefaultPlan:         getEnv("QUOTA_DEFAULT_PLAN", "free"),
+		QuotaWarningPercent: getEnvAsInt("QUOTA_WARNING_PERCENT", 80),
+	}
+
+	cfg.Providers = loadProviders(cfg)
+	cfg.DefaultProvider = getEnv("AI_DEFAULT_PROVIDER", cfg.Providers[0].Name)
+	cfg.FallbackProviders = getEnvAsList("AI_FALLBACK_PROVIDERS")
+	cfg.ModelPrices = loadModelPrices()
+	cfg.QuotaPlans = loadQuotaPlans()
+	cfg.AdminEmails = getEnvAsList("ADMIN_EMAILS")
+	cfg.TrustedProxies = getEnvAsList("TRUSTED_PROXIES")
+
+	return cfg
+}
+
+// loadProviders loads the AI providers listed in AI_PROVIDERS.
+// Each provider NAME is configured with AI_PROVIDER_NAME_TYPE, _URL, _API_KEY
+// and _MODELS (comma separated). Without AI_PROVIDERS a single provider is
+// derived from AI_API_KEY and AI_API_URL as before.
+func loadProviders(cfg *Config) []ProviderConfig {
+	names := getEnvAsList("AI_PROVIDERS")
+	if len(names) == 0 {
+		if cfg.AIAPIKey == "" {
+			return []ProviderConfig{{Name: "mock", Type: "mock"}}
+		}
+		return []ProviderConfig{{
+			Name:   "openai",
+			Type:   "openai",
+			URL:    cfg.AIAPIURL,
+			APIKey: cfg.AIAPIKey,
+			Models: getEnvAsList("AI_MODELS"),
+		}}
+	}
+
+	providers := make([]ProviderConfig, 0, len(names))
+	for _, name := range names {
+		prefix := "AI_PROVIDER_" + strings.ToUpper(name) + "_"
+		providers = append(providers, ProviderConfig{
+			Name:   name,
+			Type:   getEnv(prefix+"TYPE", "openai"),
+			URL:    getEnv(prefix+"URL", ""),
+			APIKey: getEnv(prefix+"API_KEY", ""),
+			Models: getEnvAsList(prefix + "MODELS"),
+		})
+	}
+	return providers
+}
+
+// loadModelPrices loads the prices listed in AI_MODEL_PRICES as
+// model=prompt:completion pairs in USD per million tokens, for example
+// "gpt-4o=2.5:10,claude-3-haiku=0.25:1.25". Invalid entries are skipped.
+func loadModelPrices() map[string]ModelPrice {
+	prices := make(map[string]ModelPrice)
+	for _, entry := range getEnvAsList("AI_MODEL_PRICES") {
+		model, prompt, completion, ok := parsePair(entry)
+		if !ok {
+			log.Printf("Ignoring invalid model price %q", entry)
+			continue
+		}
+		prices[model] = ModelPrice{Prompt: prompt, Completion: completion}
+	}
+	return prices
+}
+
+// loadQuotaPlans loads the plans listed in QUOTA_PLANS as
+// plan=tokens:cost pairs per month, for example "free=100000:0,pro=5000000:50".
+// Invalid entries are skipped.
+func loadQuotaPlans() map[string]QuotaPlan {
+	plans := make(map[string]QuotaPlan)
+	for _, entry := range getEnvAsList("QUOTA_PLANS") {
+		plan, tokens, cost, ok := parsePair(entry)
+		if !ok || tokens < 0 || cost < 0 {
+			log.Printf("Ignoring invalid quota plan %q", entry)
+			continue
+		}
+		plans[plan] = QuotaPlan{TokenLimit: int64(tokens), CostLimit: cost}
+	}
+	return plans
+}
+
+// parsePair parses a name=a:b entry of a list
+func parsePair(entry string) (string, float64, float64, bool) {
+	name, values, ok := strings.Cut(entry, "=")
+	first, second, ok2 := strings.Cut(values, ":")
+	if !ok || !ok2 || strings.TrimSpace(name) == "" {
+		return "", 0, 0, false
+	}
+
+	a, err := strconv.ParseFloat(strings.TrimSpace(first), 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	b, err := strconv.ParseFloat(strings.TrimSpace(second), 64)
+	if err != nil {
+		return "", 0, 0, false
 	}
+	return strings.TrimSpace(name), a, b, true
 }
 
 // getEnv gets an environment variable with a default value
@@ -45,6 +247,22 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsMillis gets an environment variable in milliseconds as a duration
+func getEnvAsMillis(key string, defaultValue int) time.Duration {
+	return time.Duration(getEnvAsInt(key, defaultValue)) * time.Millisecond
+}
+
+// getEnvAsList gets a comma separated environment variable as a list
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // getEnvAsBool gets an environment variable as boolean with a default value
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CreateAPIKeyRequest represents the request to create an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty" binding:"min=0"`
+}
+
+// CreateAPIKeyResponse represents a newly created API key. The key itself
+// is only ever returned here.
+type CreateAPIKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// GetAPIKeys retrieves the API keys of the current user
+func GetAPIKeys(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var apiKeys []models.APIKey
+		if err := db.Where("user_id = ?", currentUserID(c)).Order("created_at DESC").Find(&apiKeys).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve API keys",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"apiKeys": apiKeys})
+	}
+}
+
+// CreateAPIKey creates a new API key for the current user
+func CreateAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		var scopes models.StringList
+		seen := make(map[string]bool)
+		for _, scope := range req.Scopes {
+			if !services.ValidScope(scope) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid scope",
+					Message: "Unknown scope " + scope + ", expected one of: " + strings.Join(services.Scopes, ", "),
+					Code:    http.StatusBadRequest,
+				})
+				return
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+
+		key, keyHash, prefix, err := services.NewAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Internal error",
+				Message: "Failed to generate API key",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		apiKey := models.APIKey{
+			ID:        uuid.New().String(),
+			UserID:    currentUserID(c),
+			Name:      strings.TrimSpace(req.Name),
+			Prefix:    prefix,
+			KeyHash:   keyHash,
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := apiKey.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+			apiKey.ExpiresAt = &expiresAt
+		}
+
+		if err := db.Create(&apiKey).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to create API key",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+	}
+}
+
+// RevokeAPIKey revokes an API key of the current user
+func RevokeAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		keyID := c.Param("id")
+
+		var apiKey models.APIKey
+		if err := db.First(&apiKey, "id = ? AND user_id = ?", keyID, currentUserID(c)).Error; err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   "API key not found",
+				Message: "The requested API key does not exist",
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+
+		if apiKey.RevokedAt == nil {
+			if err := db.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error:   "Database error",
+					Message: "Failed to revoke API key",
+					Code:    http.StatusInternalServerError,
+				})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+	}
+}
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// RegisterRequest represents the request to create an account
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+	Name     string `json:"name,omitempty"`
+}
+
+// LoginRequest represents the request to log in
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest represents the request to refresh or revoke tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// AuthResponse represents the tokens issued to a user
+type AuthResponse struct {
+	User                  models.User `json:"user"`
+	AccessToken           string      `json:"accessToken"`
+	AccessTokenExpiresAt  time.Time   `json:"accessTokenExpiresAt"`
+	RefreshToken          string      `json:"refreshToken"`
+	RefreshTokenExpiresAt time.Time   `json:"refreshTokenExpiresAt"`
+	TokenType             string      `json:"tokenType"`
+}
+
+// errRefreshTokenInvalid is returned when a refresh token was rotated concurrently
+var errRefreshTokenInvalid = errors.New("invalid refresh token")
+
+// dummyPasswordHash is compared against when no user has the email, so that
+// logging in takes as long whether or not the account exists. It has the
+// cost of the hashes Register creates.
+var dummyPasswordHash = []byte("$2a$10$liqMhmVs8EsOKn5nRiMDluer1f2uSVLCnCE/sI6LIekK4DU3Qp93W")
+
+// Register creates a new user account and logs it in
+func Register(db *gorm.DB, tokens *services.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		email := normalizeEmail(req.Email)
+		var count int64
+		if err := db.Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to check email",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "Email already registered",
+				Message: "An account with this email already exists",
+				Code:    http.StatusConflict,
+			})
+			return
+		}
+
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Internal error",
+				Message: "Failed to hash password",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		user := models.User{
+			ID:           uuid.New().String(),
+			Email:        email,
+			Name:         strings.TrimSpace(req.Name),
+			PasswordHash: string(passwordHash),
+			Role:         models.RoleUser,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+
+		if err := db.Create(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to create user",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		response, errResp := issueTokens(db, tokens, user)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// Login verifies the user's credentials and issues new tokens
+func Login(db *gorm.DB, tokens *services.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req LoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		var user models.User
+		err := db.First(&user, "email = ?", normalizeEmail(req.Email)).Error
+		if err == nil {
+			err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+		} else {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Invalid email or password",
+				Code:    http.StatusUnauthorized,
+			})
+			return
+		}
+
+		response, errResp := issueTokens(db, tokens, user)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token.
+// The presented refresh token is revoked; presenting a revoked token again
+// revokes every token of the user, since it may have been stolen.
+func Refresh(db *gorm.DB, tokens *services.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		invalid := ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or expired refresh token",
+			Code:    http.StatusUnauthorized,
+		}
+
+		var current models.RefreshToken
+		if err := db.First(&current, "token_hash = ?", services.HashToken(req.RefreshToken)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusUnauthorized, invalid)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to load refresh token",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		if current.RevokedAt != nil {
+			log.Printf("User %s: revoked refresh token reused, revoking all tokens", current.UserID)
+			if err := revokeRefreshTokens(db, current.UserID); err != nil {
+				log.Printf("User %s: failed to revoke refresh tokens: %v", current.UserID, err)
+			}
+			c.JSON(http.StatusUnauthorized, invalid)
+			return
+		}
+
+		var user models.User
+		if !time.Now().Before(current.ExpiresAt) || db.First(&user, "id = ?", current.UserID).Error != nil {
+			c.JSON(http.StatusUnauthorized, invalid)
+			return
+		}
+
+		var response AuthResponse
+		err := db.Transaction(func(tx *gorm.DB) error {
+			next, err := createRefreshToken(tx, tokens, user.ID)
+			if err != nil {
+				return err
+			}
+
+			// Only one request may rotate the token
+			result := tx.Model(&models.RefreshToken{}).
+				Where("id = ? AND revoked_at IS NULL", current.ID).
+				Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errRefreshTokenInvalid
+			}
+
+			response, err = accessResponse(tokens, user)
+			if err != nil {
+				return err
+			}
+			response.RefreshToken = next.token
+			response.RefreshTokenExpiresAt = next.ExpiresAt
+			return nil
+		})
+
+		if errors.Is(err, errRefreshTokenInvalid) {
+			c.JSON(http.StatusUnauthorized, invalid)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to refresh tokens",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Logout revokes a refresh token
+func Logout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		if err := db.Model(&models.RefreshToken{}).
+			Where("token_hash = ? AND revoked_at IS NULL", services.HashToken(req.RefreshToken)).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to revoke refresh token",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+// issuedRefreshToken is a stored refresh token along with its plain value
+type issuedRefreshToken struct {
+	models.RefreshToken
+	token string
+}
+
+// issueTokens creates an access token and a new refresh token for the user
+func issueTokens(db *gorm.DB, tokens *services.TokenManager, user models.User) (AuthResponse, *ErrorResponse) {
+	refresh, err := createRefreshToken(db, tokens, user.ID)
+	if err != nil {
+		return AuthResponse{}, &ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to create refresh token",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+
+	response, err := accessResponse(tokens, user)
+	if err != nil {
+		return AuthResponse{}, &ErrorResponse{
+			Error:   "Internal error",
+			Message: "Failed to create access token",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	response.RefreshToken = refresh.token
+	response.RefreshTokenExpiresAt = refresh.ExpiresAt
+	return response, nil
+}
+
+// accessResponse builds the auth response with a new access token
+func accessResponse(tokens *services.TokenManager, user models.User) (AuthResponse, error) {
+	accessToken, expiresAt, err := tokens.IssueAccessToken(user.ID, user.Role)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	return AuthResponse{
+		User:                 user,
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: expiresAt,
+		TokenType:            "Bearer",
+	}, nil
+}
+
+// createRefreshToken stores a new refresh token for the user
+func createRefreshToken(db *gorm.DB, tokens *services.TokenManager, userID string) (issuedRefreshToken, error) {
+	token, tokenHash, expiresAt, err := tokens.NewRefreshToken()
+	if err != nil {
+		return issuedRefreshToken{}, err
+	}
+
+	refresh := issuedRefreshToken{
+		RefreshToken: models.RefreshToken{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+			CreatedAt: time.Now(),
+		},
+		token: token,
+	}
+	if err := db.Create(&refresh.RefreshToken).Error; err != nil {
+		return issuedRefreshToken{}, err
+	}
+	return refresh, nil
+}
+
+// revokeRefreshTokens revokes every active refresh token of the user
+func revokeRefreshTokens(db *gorm.DB, userID string) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// currentUserID returns the ID of the user authenticated by AuthMiddleware
+func currentUserID(c *gin.Context) string {
+	return c.GetString("user_id")
+}
+
+// normalizeEmail returns the email in the form it is stored
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
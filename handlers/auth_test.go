@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"chatbot_backend/services"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	tokens := services.NewTokenManager("test-secret", time.Minute, time.Hour)
+	router := gin.New()
+	db := newTestDB(t)
+	router.POST("/api/auth/register", Register(db, tokens))
+	router.POST("/api/auth/login", Login(db, tokens))
+
+	credentials := LoginRequest{Email: "alice@example.com", Password: "correct horse"}
+	if w := serveJSON(t, ctx, router, http.MethodPost, "/api/auth/register", credentials, nil); w.Code >= 300 {
+		t.Fatalf("register = %d: %s", w.Code, w.Body)
+	}
+
+	var response AuthResponse
+	if w := serveJSON(t, ctx, router, http.MethodPost, "/api/auth/login", credentials, &response); w.Code != http.StatusOK || response.AccessToken == "" {
+		t.Fatalf("login = %d: %s", w.Code, w.Body)
+	}
+
+	// A wrong password and an unknown email are refused alike
+	for _, attempt := range []LoginRequest{
+		{Email: "alice@example.com", Password: "wrong password"},
+		{Email: "bob@example.com", Password: "correct horse"},
+	} {
+		w := serveJSON(t, ctx, router, http.MethodPost, "/api/auth/login", attempt, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("login as %s = %d, want 401", attempt.Email, w.Code)
+		}
+	}
+
+	// Unknown emails cost a comparison as slow as a real one
+	if cost, err := bcrypt.Cost(dummyPasswordHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, %v; want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
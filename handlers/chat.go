@@ -3,42 +3,74 @@ package handlers
 import (
 	"chatbot_backend/models"
 	"chatbot_backend/services"
+	"context"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 // SendMessageRequest represents the request to send a message
 type SendMessageRequest struct {
 	Message   string `json:"message" binding:"required"`
 	SessionID string `json:"sessionId,omitempty"`
+	Provider  string `json:"provider,omitempty"` // overrides the session's provider
 }
 
 // SendMessageResponse represents the response after sending a message
 type SendMessageResponse struct {
-	Message   models.Message `json:"message"`
-	SessionID string         `json:"sessionId"`
+	Message     models.Message        `json:"message"`
+	UserMessage models.Message        `json:"userMessage"` // the message answered
+	SessionID   string                `json:"sessionId"`
+	Quota       *services.QuotaStatus `json:"quota,omitempty"` // set for users with limits
 }
 
 // RegenerateMessageRequest represents the request to regenerate a message
 type RegenerateMessageRequest struct {
 	MessageID string `json:"messageId" binding:"required"`
 	SessionID string `json:"sessionId" binding:"required"`
+	Provider  string `json:"provider,omitempty"` // overrides the session's provider
 }
 
+// EditMessageRequest represents the request to edit a user message
+type EditMessageRequest struct {
+	Message  string `json:"message" binding:"required"`
+	Provider string `json:"provider,omitempty"` // overrides the session's provider
+}
+
+// RetryMessageRequest represents the request to retry a failed bot message
+type RetryMessageRequest struct {
+	MessageID string `json:"messageId" binding:"required"`
+	SessionID string `json:"sessionId" binding:"required"`
+	Provider  string `json:"provider,omitempty"` // overrides the session's provider
+}
+
+// fallbackReply is stored as the failed bot message when every AI provider fails
+const fallbackReply = "Üzgünüm, şu anda yanıt veremiyorum. Lütfen daha sonra tekrar deneyin."
+
+// fallbackProvider is recorded as the provider of the fallback reply
+const fallbackProvider = "fallback"
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-	Code    int    `json:"code"`
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	Code       int    `json:"code"`
+	RetryAfter int    `json:"retryAfter,omitempty"` // seconds, also sent as the Retry-After header
 }
 
-// SendMessage handles sending a new message
-func SendMessage(db *gorm.DB, aiService services.AIService) gin.HandlerFunc {
+// SendMessage handles sending a new message. The user message and a pending
+// bot message are saved before the AI service is asked, and the bot message
+// is completed or failed once it answers. If the client disconnects while
+// waiting, the AI request is cancelled and the bot message is recorded as
+// cancelled.
+func SendMessage(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
 		var req SendMessageRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -49,107 +81,50 @@ func SendMessage(db *gorm.DB, aiService services.AIService) gin.HandlerFunc {
 			return
 		}
 
-		// Create or get session
-		var session models.Session
-		if req.SessionID != "" {
-			if err := db.First(&session, "id = ?", req.SessionID).Error; err != nil {
-				c.JSON(http.StatusNotFound, ErrorResponse{
-					Error:   "Session not found",
-					Message: "The specified session does not exist",
-					Code:    http.StatusNotFound,
-				})
-				return
-			}
-		} else {
-			// Create new session
-			session = models.Session{
-				ID:         uuid.New().String(),
-				Title:      "New Chat",
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
-				IsFavorite: false,
-			}
-
-			if err := db.Create(&session).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{
-					Error:   "Database error",
-					Message: "Failed to create session",
-					Code:    http.StatusInternalServerError,
-				})
-				return
-			}
-		}
-
-		// Create user message
-		userMessage := models.Message{
-			ID:          uuid.New().String(),
-			Content:     req.Message,
-			Sender:      "user",
-			Timestamp:   time.Now(),
-			MessageType: "text",
-			SessionID:   session.ID,
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
 		}
 
-		if err := db.Create(&userMessage).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to save user message",
-				Code:    http.StatusInternalServerError,
-			})
+		if errResp := checkQuota(ctx, quotas, currentUserID(c)); errResp != nil {
+			writeErrorResponse(c, errResp)
 			return
 		}
 
-		// Get AI response
-		aiResponse, err := aiService.SendMessage(req.Message)
-		var botMessage models.Message
-
+		exchange, err := chat.StartExchange(ctx, currentUserID(c), req.SessionID, req.Message)
 		if err != nil {
-			// AI service hatası olsa bile bot mesajı oluştur
-			botMessage = models.Message{
-				ID:          uuid.New().String(),
-				Content:     "Üzgünüm, şu anda yanıt veremiyorum. Lütfen daha sonra tekrar deneyin.",
-				Sender:      "bot",
-				Timestamp:   time.Now(),
-				MessageType: "text",
-				SessionID:   session.ID,
-			}
-		} else {
-			// Normal AI response
-			botMessage = models.Message{
-				ID:          uuid.New().String(),
-				Content:     aiResponse,
-				Sender:      "bot",
-				Timestamp:   time.Now(),
-				MessageType: "text",
-				SessionID:   session.ID,
-			}
-		}
-
-		// Bot mesajını kaydet
-		if err := db.Create(&botMessage).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to save bot message",
-				Code:    http.StatusInternalServerError,
-			})
+			errResp := chatErrorResponse(err, "Failed to save user message")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+		session := exchange.Session
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.UserMessage})
+
+		quota, errResp := answerExchange(ctx, chat, quotas, currentUserID(c), &exchange, req.Provider)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Update session timestamp
-		session.UpdatedAt = time.Now()
-		db.Save(&session)
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.Reply})
 
 		c.JSON(http.StatusOK, SendMessageResponse{
-			Message:   botMessage,
-			SessionID: session.ID,
+			Message:     exchange.Reply,
+			UserMessage: exchange.UserMessage,
+			SessionID:   session.ID,
+			Quota:       quota,
 		})
 	}
 }
 
-// RegenerateMessage handles regenerating a bot message
-func RegenerateMessage(db *gorm.DB, aiService services.AIService) gin.HandlerFunc {
+// EditMessage handles editing a user message. The new version is saved as a
+// sibling of the message, starting a new branch of the conversation that
+// becomes active and is answered like a new message; the old branch is kept.
+func EditMessage(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req RegenerateMessageRequest
+		ctx := c.Request.Context()
+
+		var req EditMessageRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{
 				Error:   "Invalid request",
@@ -159,96 +134,401 @@ func RegenerateMessage(db *gorm.DB, aiService services.AIService) gin.HandlerFun
 			return
 		}
 
-		// Get the original message
-		var originalMessage models.Message
-		if err := db.First(&originalMessage, "id = ?", req.MessageID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Message not found",
-				Message: "The specified message does not exist",
-				Code:    http.StatusNotFound,
-			})
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Check if session exists
-		var session models.Session
-		if err := db.First(&session, "id = ?", req.SessionID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Session not found",
-				Message: "The specified session does not exist",
-				Code:    http.StatusNotFound,
-			})
+		if errResp := checkQuota(ctx, quotas, currentUserID(c)); errResp != nil {
+			writeErrorResponse(c, errResp)
+			return
+		}
+
+		exchange, err := chat.EditMessage(ctx, currentUserID(c), c.Param("id"), req.Message)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to save user message")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+		session := exchange.Session
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.UserMessage})
+
+		quota, errResp := answerExchange(ctx, chat, quotas, currentUserID(c), &exchange, req.Provider)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Get the previous user message
-		var userMessage models.Message
-		if err := db.Where("session_id = ? AND sender = ? AND timestamp < ?",
-			req.SessionID, "user", originalMessage.Timestamp).
-			Order("timestamp DESC").First(&userMessage).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "User message not found",
-				Message: "Could not find the user message to regenerate",
-				Code:    http.StatusNotFound,
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.Reply})
+
+		c.JSON(http.StatusOK, SendMessageResponse{
+			Message:     exchange.Reply,
+			UserMessage: exchange.UserMessage,
+			SessionID:   session.ID,
+			Quota:       quota,
+		})
+	}
+}
+
+// RetryMessage handles answering a failed bot reply again. Replies left
+// pending by a server that went away can be retried as well.
+func RetryMessage(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req RetryMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
 			})
 			return
 		}
 
-		// Mark original message as regenerated
-		originalMessage.IsRegenerated = true
-		originalMessage.OriginalMessageID = originalMessage.ID
-		db.Save(&originalMessage)
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		if errResp := checkQuota(ctx, quotas, currentUserID(c)); errResp != nil {
+			writeErrorResponse(c, errResp)
+			return
+		}
 
-		// Get new AI response
-		aiResponse, err := aiService.RegenerateMessage(userMessage.Content)
+		exchange, err := chat.PrepareRetry(ctx, currentUserID(c), req.SessionID, req.MessageID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "AI Service error",
-				Message: "Failed to regenerate message",
-				Code:    http.StatusInternalServerError,
-			})
+			errResp := chatErrorResponse(err, "Failed to load conversation history")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Create new bot message
-		newMessage := models.Message{
-			ID:                uuid.New().String(),
-			Content:           aiResponse,
-			Sender:            "bot",
-			Timestamp:         time.Now(),
-			MessageType:       "text",
-			SessionID:         req.SessionID,
-			IsRegenerated:     true,
-			OriginalMessageID: req.MessageID,
+		quota, errResp := answerExchange(ctx, chat, quotas, currentUserID(c), &exchange, req.Provider)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
 		}
 
-		if err := db.Create(&newMessage).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to save regenerated message",
-				Code:    http.StatusInternalServerError,
+		hub.Publish(req.SessionID, services.Event{Type: services.EventMessage, Data: exchange.Reply})
+
+		c.JSON(http.StatusOK, SendMessageResponse{
+			Message:     exchange.Reply,
+			UserMessage: exchange.UserMessage,
+			SessionID:   req.SessionID,
+			Quota:       quota,
+		})
+	}
+}
+
+// answerExchange asks the AI service to answer the exchange and records its
+// pending reply as completed, as cancelled if the client went away first, or
+// as failed with an apology. It returns the user's quota if they have limits,
+// or the error response to send if the reply could not be saved.
+func answerExchange(ctx context.Context, chat *services.ChatService, quotas *services.QuotaService, userID string, exchange *services.Exchange, providerName string) (*services.QuotaStatus, *ErrorResponse) {
+	completion, answeredBy, err := chat.Reply(ctx, exchange, providerName, nil)
+
+	cancelled := err != nil && ctx.Err() != nil
+	if cancelled {
+		// Keep recording the exchange now that the request context is done
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	// Tokens are paid for even when the reply was cancelled
+	quota := recordQuota(ctx, quotas, userID, exchange.Reply.SessionID, completion)
+
+	if err != nil && !cancelled {
+		log.Printf("Session %s: AI service error: %v", exchange.Session.ID, err)
+
+		// AI service hatası olsa bile bot mesajı oluştur
+		err = chat.FailReply(ctx, exchange, fallbackReply, completion, fallbackProvider)
+	} else {
+		err = chat.CompleteReply(ctx, exchange, completion, answeredBy, cancelled)
+	}
+	if err != nil {
+		log.Printf("Session %s: failed to save bot message: %v", exchange.Session.ID, err)
+		return quota, &ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to save bot message",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	return quota, nil
+}
+
+// RegenerateMessage handles regenerating a bot message
+func RegenerateMessage(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req RegenerateMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
 			})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": newMessage})
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		newMessage, quota, errResp := regenerateReply(ctx, chat, quotas, currentUserID(c), req.SessionID, req.MessageID, req.Provider)
+		if errResp != nil {
+			writeErrorResponse(c, errResp)
+			return
+		}
+
+		hub.Publish(req.SessionID, services.Event{Type: services.EventRegenerated, Data: newMessage})
+
+		response := gin.H{"message": newMessage}
+		if quota != nil {
+			response["quota"] = quota
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// regenerateReply creates a new bot reply to the user message that preceded
+// the given bot message in one of the user's sessions, saved as cancelled,
+// without replacing the selected version, if the client went away first. It returns the user's quota if they have
+// limits, or the error response to send on failure.
+func regenerateReply(ctx context.Context, chat *services.ChatService, quotas *services.QuotaService, userID, sessionID, messageID, providerName string) (models.Message, *services.QuotaStatus, *ErrorResponse) {
+	regeneration, err := chat.PrepareRegeneration(ctx, userID, sessionID, messageID)
+	if err != nil {
+		return models.Message{}, nil, chatErrorResponse(err, "Failed to load conversation history")
+	}
+
+	if errResp := checkQuota(ctx, quotas, userID); errResp != nil {
+		return models.Message{}, nil, errResp
+	}
+
+	// Get new AI response
+	newMessage, completion, err := chat.Regenerate(ctx, &regeneration, providerName)
+
+	cancelled := err != nil && ctx.Err() != nil
+	if cancelled {
+		// Keep recording the version now that the request context is done
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	// Tokens are paid for even when the regeneration failed or was cancelled
+	quota := recordQuota(ctx, quotas, userID, sessionID, completion)
+
+	if err != nil && !cancelled {
+		log.Printf("Session %s: AI service error: %v", sessionID, err)
+		return models.Message{}, quota, aiErrorResponse(err, "Failed to regenerate message")
+	}
+
+	if err := chat.CreateReply(ctx, &newMessage); err != nil {
+		return models.Message{}, quota, &ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to save regenerated message",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+
+	return newMessage, quota, nil
+}
+
+// writeErrorResponse sends an error response, with the Retry-After header if set
+func writeErrorResponse(c *gin.Context, errResp *ErrorResponse) {
+	if errResp.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(errResp.RetryAfter))
+	}
+	c.JSON(errResp.Code, *errResp)
+}
+
+// aiErrorResponse maps an AI service error to the error response to send.
+// Rate limits and outages tell the client when to retry; prompts that are too
+// long for the model are the client's to fix.
+func aiErrorResponse(err error, message string) *ErrorResponse {
+	errResp := &ErrorResponse{
+		Error:   "AI Service error",
+		Message: message,
+		Code:    http.StatusInternalServerError,
+	}
+
+	if errors.Is(err, services.ErrCircuitOpen) {
+		errResp.Code = http.StatusServiceUnavailable
+		errResp.Message = "The AI provider is temporarily unavailable, please try again later"
+		return errResp
+	}
+
+	providerErr, ok := services.AsProviderError(err)
+	if !ok {
+		return errResp
+	}
+
+	switch {
+	case providerErr.IsContextLengthError():
+		errResp.Code = http.StatusBadRequest
+		errResp.Message = "The conversation is too long for the selected model"
+	case providerErr.StatusCode == http.StatusTooManyRequests:
+		errResp.Code = http.StatusTooManyRequests
+		errResp.Message = "The AI provider is rate limiting requests, please try again later"
+	case providerErr.Retryable:
+		errResp.Code = http.StatusServiceUnavailable
+		errResp.Message = "The AI provider is temporarily unavailable, please try again later"
+	default:
+		errResp.Code = http.StatusBadGateway
+	}
+
+	if providerErr.Retryable && providerErr.RetryAfter > 0 {
+		errResp.RetryAfter = int(math.Ceil(providerErr.RetryAfter.Seconds()))
+	}
+	return errResp
+}
+
+// chatErrorResponse maps an error from the chat service to the error
+// response to send. Other users' sessions are reported as missing, so that
+// they cannot be told apart from missing ones; unexpected errors are
+// reported with the given message.
+func chatErrorResponse(err error, message string) *ErrorResponse {
+	var settingsErr *services.SettingsError
+	switch {
+	case errors.Is(err, services.ErrSessionNotFound):
+		return &ErrorResponse{
+			Error:   "Session not found",
+			Message: "The specified session does not exist",
+			Code:    http.StatusNotFound,
+		}
+	case errors.Is(err, services.ErrMessageNotFound):
+		return &ErrorResponse{
+			Error:   "Message not found",
+			Message: "The specified message does not exist",
+			Code:    http.StatusNotFound,
+		}
+	case errors.Is(err, services.ErrNotRetryable):
+		return &ErrorResponse{
+			Error:   "Message not retryable",
+			Message: "Only failed bot messages can be retried",
+			Code:    http.StatusConflict,
+		}
+	case errors.Is(err, services.ErrNotEditable):
+		return &ErrorResponse{
+			Error:   "Message not editable",
+			Message: "Only user messages can be edited",
+			Code:    http.StatusBadRequest,
+		}
+	case errors.Is(err, services.ErrNoUserMessage):
+		return &ErrorResponse{
+			Error:   "User message not found",
+			Message: "Could not find the user message the bot message answered",
+			Code:    http.StatusNotFound,
+		}
+	case errors.Is(err, services.ErrInvalidCursor):
+		return &ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid page cursor",
+			Code:    http.StatusBadRequest,
+		}
+	case errors.Is(err, services.ErrPersonaNotFound):
+		return &ErrorResponse{
+			Error:   "Invalid request",
+			Message: "The specified persona does not exist",
+			Code:    http.StatusBadRequest,
+		}
+	case errors.As(err, &settingsErr):
+		return &ErrorResponse{
+			Error:   "Invalid generation settings",
+			Message: settingsErr.Error(),
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	log.Printf("Chat service error: %v", err)
+	return &ErrorResponse{
+		Error:   "Database error",
+		Message: message,
+		Code:    http.StatusInternalServerError,
+	}
+}
+
+// PageQuery represents the query parameters of a paginated list. Before and
+// after take the nextCursor of a previous response.
+type PageQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1"` // capped at services.MaxPageSize
+	Before string `form:"before"`
+	After  string `form:"after"`
+}
+
+// pageRequest reads the page requested in the query string
+func pageRequest(c *gin.Context) (services.PageRequest, *ErrorResponse) {
+	var query PageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return services.PageRequest{}, &ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		}
+	}
+	return services.PageRequest{Limit: query.Limit, Before: query.Before, After: query.After}, nil
+}
+
+// checkProvider validates the provider requested by the client
+func checkProvider(chat *services.ChatService, name string) *ErrorResponse {
+	if chat.HasProvider(name) {
+		return nil
+	}
+	return &ErrorResponse{
+		Error:   "Invalid request",
+		Message: "Unknown AI provider: " + name,
+		Code:    http.StatusBadRequest,
 	}
 }
 
-// GetMessages retrieves messages for a session
-func GetMessages(db *gorm.DB) gin.HandlerFunc {
+// GetMessages retrieves a page of the messages of a session's active branch,
+// or of every branch with ?all=true, in chronological order. The first page
+// holds the latest messages; nextCursor, passed as before, fetches the ones
+// preceding them and is empty once there are none.
+func GetMessages(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.Param("id")
+		all := c.Query("all") == "true"
 
-		var messages []models.Message
-		if err := db.Where("session_id = ?", sessionID).
-			Order("timestamp ASC").
-			Find(&messages).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to retrieve messages",
-				Code:    http.StatusInternalServerError,
-			})
+		request, errResp := pageRequest(c)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		page, err := chat.GetMessages(c.Request.Context(), currentUserID(c), sessionID, all, request)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve messages")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"messages": page.Items, "nextCursor": page.NextCursor})
+	}
+}
+
+// GetVersions lists the versions of a message's turn: a bot reply and its
+// regenerations, or a user message and its edits
+func GetVersions(chat *services.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		versions, err := chat.GetVersions(c.Request.Context(), currentUserID(c), c.Param("id"))
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve versions")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"versions": versions})
+	}
+}
+
+// SelectVersion makes a version of a message's turn the one later messages
+// follow, and returns the messages of the resulting active branch
+func SelectVersion(chat *services.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messages, err := chat.SelectVersion(c.Request.Context(), currentUserID(c), c.Param("id"))
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to select version")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
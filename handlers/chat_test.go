@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockingUpstream is an OpenAI compatible server that answers the first
+// requests and then blocks every request until the client cancels it
+type blockingUpstream struct {
+	*httptest.Server
+	answers   int32
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func newBlockingUpstream(t *testing.T, answers int32) *blockingUpstream {
+	upstream := &blockingUpstream{
+		answers:   answers,
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&upstream.answers, -1) >= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"model":"test-model","choices":[{"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`)
+			return
+		}
+		// The server only notices the client going away once the body is read
+		io.Copy(io.Discard, r.Body)
+		close(upstream.started)
+		<-r.Context().Done()
+		close(upstream.cancelled)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// service returns an OpenAI service that sends its requests to the upstream
+func (u *blockingUpstream) service() *services.OpenAIService {
+	return &services.OpenAIService{APIURL: u.URL, Model: "test-model", Client: u.Client()}
+}
+
+// serveCancelled sends the request and cancels it once the upstream received
+// the AI request, then waits until the upstream and the handler saw it
+func serveCancelled(t *testing.T, upstream *blockingUpstream, router http.Handler, method, path string, body interface{}) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		serveJSON(t, ctx, router, method, path, body, nil)
+	}()
+
+	wait := func(what string, done <-chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	wait("the upstream request", upstream.started)
+	cancel()
+	wait("the upstream to see the cancellation", upstream.cancelled)
+	wait("the handler", served)
+}
+
+// sessionMessages returns every message of the user's only session
+func sessionMessages(t *testing.T, chat *services.ChatService, userID string) []models.Message {
+	t.Helper()
+
+	ctx := context.Background()
+	sessions, err := chat.GetSessions(ctx, userID, services.PageRequest{})
+	if err != nil || len(sessions.Items) != 1 {
+		t.Fatalf("GetSessions = %d sessions, %v; want 1", len(sessions.Items), err)
+	}
+	messages, err := chat.GetMessages(ctx, userID, sessions.Items[0].ID, true, services.PageRequest{Limit: services.MaxPageSize})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	return messages.Items
+}
+
+func TestSendMessageCancelled(t *testing.T) {
+	upstream := newBlockingUpstream(t, 0)
+	chat := newTestChat(upstream.service())
+
+	router := gin.New()
+	router.Use(asUser("alice"))
+	router.POST("/api/chat/send", SendMessage(chat, services.NewHub(), newTestQuotas(t)))
+
+	serveCancelled(t, upstream, router, http.MethodPost, "/api/chat/send", SendMessageRequest{Message: "Hi"})
+
+	messages := sessionMessages(t, chat, "alice")
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want the user message and its reply", len(messages))
+	}
+	reply := messages[1]
+	if reply.Sender != "bot" || !reply.IsCancelled || reply.Status != models.MessageStatusCompleted {
+		t.Errorf("reply = sender %q, cancelled %v, status %q; want a completed, cancelled bot message",
+			reply.Sender, reply.IsCancelled, reply.Status)
+	}
+}
+
+func TestRegenerateMessageCancelled(t *testing.T) {
+	upstream := newBlockingUpstream(t, 1)
+	chat := newTestChat(upstream.service())
+
+	router := gin.New()
+	router.Use(asUser("alice"))
+	router.POST("/api/chat/send", SendMessage(chat, services.NewHub(), newTestQuotas(t)))
+	router.POST("/api/chat/regenerate", RegenerateMessage(chat, services.NewHub(), newTestQuotas(t)))
+
+	var sent SendMessageResponse
+	if w := serveJSON(t, context.Background(), router, http.MethodPost, "/api/chat/send", SendMessageRequest{Message: "Hi"}, &sent); w.Code != http.StatusOK {
+		t.Fatalf("send: status %d: %s", w.Code, w.Body)
+	}
+
+	serveCancelled(t, upstream, router, http.MethodPost, "/api/chat/regenerate", RegenerateMessageRequest{
+		MessageID: sent.Message.ID,
+		SessionID: sent.SessionID,
+	})
+
+	messages := sessionMessages(t, chat, "alice")
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want the user message and two versions of its reply", len(messages))
+	}
+	var regenerated *models.Message
+	for i := range messages {
+		if messages[i].IsRegenerated {
+			regenerated = &messages[i]
+		}
+	}
+	if regenerated == nil || !regenerated.IsCancelled || regenerated.OriginalMessageID != sent.Message.ID {
+		t.Errorf("regenerated = %+v, want a cancelled version of %s", regenerated, sent.Message.ID)
+	}
+
+	// The reply the user had selected stays active
+	session, _, err := chat.GetSession(context.Background(), "alice", sent.SessionID, 0)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.ActiveMessageID == nil || *session.ActiveMessageID != sent.Message.ID {
+		t.Errorf("active message = %v, want the first version %s", session.ActiveMessageID, sent.Message.ID)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"chatbot_backend/config"
+	"chatbot_backend/migrations"
+	"chatbot_backend/repository"
+	"chatbot_backend/services"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestChat returns a chat service on an in-memory store that answers with
+// the given AI service
+func newTestChat(ai services.AIService) *services.ChatService {
+	registry := services.NewRegistry()
+	registry.Register("test", services.ProviderOpenAI, nil, ai)
+	return services.NewChatService(repository.NewMemoryStore().Repositories(), registry, services.NewContextBuilder(4000))
+}
+
+// newTestDB returns a migrated SQLite database
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return db
+}
+
+// newTestQuotas returns a quota service without limits on a fresh SQLite database
+func newTestQuotas(t *testing.T) *services.QuotaService {
+	t.Helper()
+	return services.NewQuotaService(newTestDB(t), &config.Config{})
+}
+
+// asUser authenticates every request as the given user
+func asUser(userID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Next()
+	}
+}
+
+// serveJSON sends a request with the body, if any, as JSON to the router and
+// decodes a successful response into out unless it is nil
+func serveJSON(t *testing.T, ctx context.Context, router http.Handler, method, path string, body, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload).WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if out != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return w
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CreatePersonaRequest represents the request to create a persona
+type CreatePersonaRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	SystemPrompt  string   `json:"systemPrompt" binding:"required"`
+	Avatar        string   `json:"avatar,omitempty"`
+	Provider      string   `json:"provider,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"topP,omitempty"`
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+	Shared        bool     `json:"shared,omitempty"` // visible to every user; admins only
+}
+
+// UpdatePersonaRequest represents the request to update a persona
+type UpdatePersonaRequest struct {
+	Name          *string   `json:"name,omitempty"`
+	SystemPrompt  *string   `json:"systemPrompt,omitempty"`
+	Avatar        *string   `json:"avatar,omitempty"`
+	Provider      *string   `json:"provider,omitempty"`
+	Model         *string   `json:"model,omitempty"`
+	Temperature   *float64  `json:"temperature,omitempty"`
+	TopP          *float64  `json:"topP,omitempty"`
+	MaxTokens     *int      `json:"maxTokens,omitempty"`
+	StopSequences *[]string `json:"stopSequences,omitempty"`
+}
+
+// GetPersonas retrieves the shared personas and the current user's own
+func GetPersonas(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var personas []models.Persona
+		if err := visiblePersonas(db, c).Order("name ASC").Find(&personas).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve personas",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"personas": personas})
+	}
+}
+
+// CreatePersona creates a new persona owned by the current user, or a shared
+// one if an admin asks for it
+func CreatePersona(db *gorm.DB, registry *services.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req CreatePersonaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		if req.Shared && !isAdmin(c) {
+			c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "Forbidden",
+				Message: "Only admins can create shared personas",
+				Code:    http.StatusForbidden,
+			})
+			return
+		}
+
+		persona := models.Persona{
+			ID:            uuid.New().String(),
+			Name:          req.Name,
+			SystemPrompt:  req.SystemPrompt,
+			Avatar:        req.Avatar,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+			Provider:      req.Provider,
+			Model:         req.Model,
+			Temperature:   req.Temperature,
+			TopP:          req.TopP,
+			MaxTokens:     req.MaxTokens,
+			StopSequences: req.StopSequences,
+		}
+		if !req.Shared {
+			userID := currentUserID(c)
+			persona.UserID = &userID
+		}
+
+		if errResp := validatePersona(registry, persona); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		if err := db.Create(&persona).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to create persona",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"persona": persona})
+	}
+}
+
+// GetPersona retrieves a shared persona or one of the current user's own
+func GetPersona(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		persona, errResp := findPersona(db, c, c.Param("id"))
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"persona": persona})
+	}
+}
+
+// UpdatePersona updates one of the current user's personas, or a shared one
+// if the user is an admin
+func UpdatePersona(db *gorm.DB, registry *services.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		var req UpdatePersonaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		persona, errResp := editablePersona(db, c, c.Param("id"))
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		// Update fields if provided
+		if req.Name != nil && *req.Name != "" {
+			persona.Name = *req.Name
+		}
+		if req.SystemPrompt != nil && *req.SystemPrompt != "" {
+			persona.SystemPrompt = *req.SystemPrompt
+		}
+		if req.Avatar != nil {
+			persona.Avatar = *req.Avatar
+		}
+		if req.Provider != nil {
+			persona.Provider = *req.Provider
+		}
+		if req.Model != nil {
+			persona.Model = *req.Model
+		}
+		if req.Temperature != nil {
+			persona.Temperature = req.Temperature
+		}
+		if req.TopP != nil {
+			persona.TopP = req.TopP
+		}
+		if req.MaxTokens != nil {
+			persona.MaxTokens = *req.MaxTokens
+		}
+		if req.StopSequences != nil {
+			persona.StopSequences = *req.StopSequences
+		}
+		persona.UpdatedAt = time.Now()
+
+		if errResp := validatePersona(registry, persona); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		if err := db.Save(&persona).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to update persona",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"persona": persona})
+	}
+}
+
+// DeletePersona deletes one of the current user's personas, or a shared one
+// if the user is an admin; sessions using it fall back to the defaults
+func DeletePersona(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		persona, errResp := editablePersona(db, c, c.Param("id"))
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		// Detach the persona from its sessions first
+		if err := db.Model(&models.Session{}).Where("persona_id = ?", persona.ID).
+			Update("persona_id", nil).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to detach persona from sessions",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		if err := db.Delete(&persona).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to delete persona",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Persona deleted successfully"})
+	}
+}
+
+// visiblePersonas scopes a query to the shared personas and the current user's own
+func visiblePersonas(db *gorm.DB, c *gin.Context) *gorm.DB {
+	return db.Where("user_id IS NULL OR user_id = ?", currentUserID(c))
+}
+
+// findPersona loads a persona the current user can see. Other users'
+// personas are reported as missing, so that they cannot be told apart from
+// missing ones.
+func findPersona(db *gorm.DB, c *gin.Context, personaID string) (models.Persona, *ErrorResponse) {
+	var persona models.Persona
+	if err := visiblePersonas(db, c).First(&persona, "id = ?", personaID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return persona, &ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve persona",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		return persona, &ErrorResponse{
+			Error:   "Persona not found",
+			Message: "The specified persona does not exist",
+			Code:    http.StatusNotFound,
+		}
+	}
+	return persona, nil
+}
+
+// editablePersona loads a persona the current user may change: one of their
+// own, or a shared one if they are an admin
+func editablePersona(db *gorm.DB, c *gin.Context, personaID string) (models.Persona, *ErrorResponse) {
+	persona, errResp := findPersona(db, c, personaID)
+	if errResp != nil {
+		return persona, errResp
+	}
+	if persona.UserID == nil && !isAdmin(c) {
+		return persona, &ErrorResponse{
+			Error:   "Forbidden",
+			Message: "Only admins can change shared personas",
+			Code:    http.StatusForbidden,
+		}
+	}
+	return persona, nil
+}
+
+// isAdmin reports whether the request was made by an admin logged in with an
+// access token; API keys carry no role
+func isAdmin(c *gin.Context) bool {
+	return c.GetString("role") == models.RoleAdmin
+}
+
+// validatePersona checks the persona's name and default generation settings
+func validatePersona(registry *services.Registry, persona models.Persona) *ErrorResponse {
+	if len(persona.Name) > 100 {
+		return &ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Persona name must be at most 100 characters",
+			Code:    http.StatusBadRequest,
+		}
+	}
+	if len(persona.Avatar) > 500 {
+		return &ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Persona avatar must be at most 500 characters",
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	providerName, settings := services.PersonaSettings(persona)
+	if err := registry.ValidateSettings(providerName, settings); err != nil {
+		return &ErrorResponse{
+			Error:   "Invalid generation settings",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"chatbot_backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProviders lists the configured AI providers and their models
+func GetProviders(registry *services.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"providers":       registry.List(),
+			"defaultProvider": registry.DefaultName(),
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UpdateQuotaRequest represents an admin's change to a user's quota.
+// A negative limit removes the override so the plan's limit applies.
+type UpdateQuotaRequest struct {
+	Plan       *string  `json:"plan,omitempty"`
+	TokenLimit *int64   `json:"tokenLimit,omitempty"`
+	CostLimit  *float64 `json:"costLimit,omitempty"`
+}
+
+// GetQuota retrieves the quota of the current user
+func GetQuota(quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := quotas.Status(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve quota",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"quota": status})
+	}
+}
+
+// GetUserQuota retrieves the quota of a user for an admin
+func GetUserQuota(db *gorm.DB, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.Param("id")
+
+		if errResp := checkUserExists(db, userID); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		status, err := quotas.Status(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve quota",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"quota": status})
+	}
+}
+
+// UpdateUserQuota changes the plan or limits of a user for an admin
+func UpdateUserQuota(db *gorm.DB, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.Param("id")
+
+		var req UpdateQuotaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		if errResp := checkUserExists(db, userID); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		status, err := quotas.Update(c.Request.Context(), userID, services.QuotaUpdate{
+			Plan:       req.Plan,
+			TokenLimit: req.TokenLimit,
+			CostLimit:  req.CostLimit,
+		})
+		if errors.Is(err, services.ErrUnknownPlan) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid plan",
+				Message: "The plan " + *req.Plan + " is not configured",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to update quota",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"quota": status})
+	}
+}
+
+// ResetUserQuota clears the usage of a user in the current period for an admin
+func ResetUserQuota(db *gorm.DB, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.Param("id")
+
+		if errResp := checkUserExists(db, userID); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		status, err := quotas.Reset(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to reset quota",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"quota": status})
+	}
+}
+
+// checkUserExists returns a 404 error response unless the user exists
+func checkUserExists(db *gorm.DB, userID string) *ErrorResponse {
+	var count int64
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return &ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to load user",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	if count == 0 {
+		return &ErrorResponse{
+			Error:   "User not found",
+			Message: "The requested user does not exist",
+			Code:    http.StatusNotFound,
+		}
+	}
+	return nil
+}
+
+// checkQuota returns the error response to send if the user may not make
+// another AI request. An exhausted spending limit needs a plan change (402);
+// an exhausted token quota lasts until the period resets (429).
+func checkQuota(ctx context.Context, quotas *services.QuotaService, userID string) *ErrorResponse {
+	status, err := quotas.Check(ctx, userID)
+	switch {
+	case errors.Is(err, services.ErrCostLimitExceeded):
+		return &ErrorResponse{
+			Error:   "Spending limit exceeded",
+			Message: "You have reached your monthly spending limit",
+			Code:    http.StatusPaymentRequired,
+		}
+	case errors.Is(err, services.ErrTokenQuotaExceeded):
+		return &ErrorResponse{
+			Error:      "Quota exceeded",
+			Message:    "You have used your monthly token quota",
+			Code:       http.StatusTooManyRequests,
+			RetryAfter: int(math.Ceil(time.Until(status.ResetsAt).Seconds())),
+		}
+	case err != nil:
+		return &ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to check quota",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	return nil
+}
+
+// recordQuota adds a completion in the session to the user's usage and
+// returns the quota to include in the response, or nil if the user has no
+// limits
+func recordQuota(ctx context.Context, quotas *services.QuotaService, userID, sessionID string, completion services.Completion) *services.QuotaStatus {
+	// The tokens were used even if the client has gone away
+	status, err := quotas.Record(context.WithoutCancel(ctx), userID, sessionID, completion)
+	if err != nil {
+		log.Printf("User %s: failed to record quota usage: %v", userID, err)
+		return nil
+	}
+	if !status.Limited() {
+		return nil
+	}
+	return &status
+}
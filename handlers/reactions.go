@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReactionRequest represents the request to add or remove a reaction. The
+// emoji is read from the JSON body, or from the query string on DELETE.
+type ReactionRequest struct {
+	Emoji string `json:"emoji" form:"emoji" binding:"required,max=32"`
+}
+
+// reactionChange adds or removes a user's reaction to a message
+type reactionChange func(ctx context.Context, userID, messageID, emoji string) (models.Message, error)
+
+// AddReaction handles adding the current user's emoji reaction to a message
+func AddReaction(chat *services.ChatService, hub *services.Hub) gin.HandlerFunc {
+	return changeReaction(hub, chat.AddReaction)
+}
+
+// RemoveReaction handles removing the current user's emoji reaction from a message
+func RemoveReaction(chat *services.ChatService, hub *services.Hub) gin.HandlerFunc {
+	return changeReaction(hub, chat.RemoveReaction)
+}
+
+// changeReaction applies a reaction change and sends the message's reaction
+// counts to the client and to every client of its session
+func changeReaction(hub *services.Hub, change reactionChange) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReactionRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		emoji := strings.TrimSpace(req.Emoji)
+		if emoji == "" {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "Emoji is required",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		message, err := change(c.Request.Context(), currentUserID(c), c.Param("id"), emoji)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to update reactions")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		event := services.ReactionEvent{
+			MessageID: message.ID,
+			Reactions: models.CountReactions(message.Reactions),
+		}
+		hub.Publish(message.SessionID, services.Event{Type: services.EventReaction, Data: event})
+
+		c.JSON(http.StatusOK, event)
+	}
+}
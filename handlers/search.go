@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"chatbot_backend/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchQuery represents the query parameters of a search
+type SearchQuery struct {
+	Q         string `form:"q" binding:"required"`
+	Sender    string `form:"sender" binding:"omitempty,oneof=user bot"`
+	From      string `form:"from"` // YYYY-MM-DD
+	To        string `form:"to"`   // YYYY-MM-DD, inclusive
+	Favorites bool   `form:"favorites"`
+	Limit     int    `form:"limit" binding:"omitempty,min=1"` // capped at services.MaxPageSize
+}
+
+// Search searches the contents of the current user's messages and the titles
+// of their sessions. Hits are ranked best first and carry the session and
+// message IDs to jump to, with a snippet highlighting the matches.
+func Search(chat *services.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query SearchQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		request := services.SearchRequest{
+			Query:         query.Q,
+			Sender:        query.Sender,
+			FavoritesOnly: query.Favorites,
+			Limit:         query.Limit,
+		}
+		if errResp := searchRange(query.From, query.To, &request); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		results, err := chat.Search(c.Request.Context(), currentUserID(c), request)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to search")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
+
+// searchRange sets the date range of the search request from the query
+func searchRange(from, to string, request *services.SearchRequest) *ErrorResponse {
+	invalid := func(message string) *ErrorResponse {
+		return &ErrorResponse{
+			Error:   "Invalid request",
+			Message: message,
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	if from != "" {
+		start, err := time.Parse(usageDateLayout, from)
+		if err != nil {
+			return invalid("from must be formatted as YYYY-MM-DD")
+		}
+		request.From = &start
+	}
+	if to != "" {
+		end, err := time.Parse(usageDateLayout, to)
+		if err != nil {
+			return invalid("to must be formatted as YYYY-MM-DD")
+		}
+		end = end.AddDate(0, 0, 1)
+		request.To = &end
+	}
+	if request.From != nil && request.To != nil && !request.From.Before(*request.To) {
+		return invalid("from must not be after to")
+	}
+	return nil
+}
@@ -2,44 +2,63 @@ package handlers
 
 import (
 	"chatbot_backend/models"
+	"chatbot_backend/services"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 // CreateSessionRequest represents the request to create a new session
 type CreateSessionRequest struct {
-	Title string `json:"title"`
+	Title        string `json:"title"`
+	Provider     string `json:"provider,omitempty"`
+	PersonaID    string `json:"personaId,omitempty"`
+	SystemPrompt string `json:"systemPrompt,omitempty"`
 }
 
 // UpdateSessionRequest represents the request to update a session
 type UpdateSessionRequest struct {
-	Title      string `json:"title,omitempty"`
-	IsFavorite *bool  `json:"isFavorite,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	IsFavorite *bool   `json:"isFavorite,omitempty"`
+	Provider   *string `json:"provider,omitempty"` // empty string resets to the default
+
+	// Persona and system prompt override; empty strings detach or clear them
+	PersonaID    *string `json:"personaId,omitempty"`
+	SystemPrompt *string `json:"systemPrompt,omitempty"`
+
+	// Generation settings; ResetSettings clears them before the others apply
+	Model         *string   `json:"model,omitempty"`
+	Temperature   *float64  `json:"temperature,omitempty"`
+	TopP          *float64  `json:"topP,omitempty"`
+	MaxTokens     *int      `json:"maxTokens,omitempty"`
+	StopSequences *[]string `json:"stopSequences,omitempty"`
+	ResetSettings bool      `json:"resetSettings,omitempty"`
 }
 
-// GetSessions retrieves all sessions
-func GetSessions(db *gorm.DB) gin.HandlerFunc {
+// GetSessions retrieves a page of the current user's sessions, most recently
+// updated first. nextCursor, passed as before, fetches the next page and is
+// empty on the last one.
+func GetSessions(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var sessions []models.Session
-		if err := db.Order("updated_at DESC").Find(&sessions).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to retrieve sessions",
-				Code:    http.StatusInternalServerError,
-			})
+		request, errResp := pageRequest(c)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+		page, err := chat.GetSessions(c.Request.Context(), currentUserID(c), request)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve sessions")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sessions": page.Items, "nextCursor": page.NextCursor})
 	}
 }
 
 // CreateSession creates a new session
-func CreateSession(db *gorm.DB) gin.HandlerFunc {
+func CreateSession(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req CreateSessionRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -51,25 +70,30 @@ func CreateSession(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
 		// Set default title if not provided
 		if req.Title == "" {
 			req.Title = "New Chat"
 		}
 
 		session := models.Session{
-			ID:         uuid.New().String(),
-			Title:      req.Title,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
-			IsFavorite: false,
-		}
-
-		if err := db.Create(&session).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to create session",
-				Code:    http.StatusInternalServerError,
-			})
+			UserID:       currentUserID(c),
+			Title:        req.Title,
+			IsFavorite:   false,
+			Provider:     req.Provider,
+			SystemPrompt: req.SystemPrompt,
+		}
+		if req.PersonaID != "" {
+			session.PersonaID = &req.PersonaID
+		}
+
+		if err := chat.CreateSession(c.Request.Context(), &session); err != nil {
+			errResp := chatErrorResponse(err, "Failed to create session")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
@@ -77,28 +101,32 @@ func CreateSession(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// GetSession retrieves a specific session
-func GetSession(db *gorm.DB) gin.HandlerFunc {
+// GetSession retrieves a specific session with the latest page of messages of
+// its active branch. nextCursor fetches the earlier messages from
+// GET /api/chat/messages/:id as the before cursor.
+func GetSession(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.Param("id")
 
-		var session models.Session
-		if err := db.Preload("Messages").
-			First(&session, "id = ?", sessionID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Session not found",
-				Message: "The specified session does not exist",
-				Code:    http.StatusNotFound,
-			})
+		request, errResp := pageRequest(c)
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"session": session})
+		session, nextCursor, err := chat.GetSession(c.Request.Context(), currentUserID(c), sessionID, request.Limit)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve session")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"session": session, "nextCursor": nextCursor})
 	}
 }
 
 // UpdateSession updates a session
-func UpdateSession(db *gorm.DB) gin.HandlerFunc {
+func UpdateSession(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.Param("id")
 
@@ -112,31 +140,38 @@ func UpdateSession(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var session models.Session
-		if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Session not found",
-				Message: "The specified session does not exist",
-				Code:    http.StatusNotFound,
-			})
-			return
-		}
-
-		// Update fields if provided
-		if req.Title != "" {
-			session.Title = req.Title
-		}
-		if req.IsFavorite != nil {
-			session.IsFavorite = *req.IsFavorite
+		if req.Provider != nil {
+			if errResp := checkProvider(chat, *req.Provider); errResp != nil {
+				c.JSON(errResp.Code, *errResp)
+				return
+			}
 		}
-		session.UpdatedAt = time.Now()
 
-		if err := db.Save(&session).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to update session",
-				Code:    http.StatusInternalServerError,
-			})
+		session, err := chat.UpdateSession(c.Request.Context(), currentUserID(c), sessionID, func(session *models.Session) {
+			// Update fields if provided
+			if req.Title != "" {
+				session.Title = req.Title
+			}
+			if req.IsFavorite != nil {
+				session.IsFavorite = *req.IsFavorite
+			}
+			if req.Provider != nil {
+				session.Provider = *req.Provider
+			}
+			if req.PersonaID != nil {
+				session.PersonaID = nil
+				if *req.PersonaID != "" {
+					session.PersonaID = req.PersonaID
+				}
+			}
+			if req.SystemPrompt != nil {
+				session.SystemPrompt = *req.SystemPrompt
+			}
+			applyGenerationSettings(session, req)
+		})
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to update session")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
@@ -144,77 +179,111 @@ func UpdateSession(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// applyGenerationSettings updates the session's generation settings from the request
+func applyGenerationSettings(session *models.Session, req UpdateSessionRequest) {
+	if req.ResetSettings {
+		session.Model = ""
+		session.Temperature = nil
+		session.TopP = nil
+		session.MaxTokens = 0
+		session.StopSequences = nil
+	}
+
+	if req.Model != nil {
+		session.Model = *req.Model
+	}
+	if req.Temperature != nil {
+		session.Temperature = req.Temperature
+	}
+	if req.TopP != nil {
+		session.TopP = req.TopP
+	}
+	if req.MaxTokens != nil {
+		session.MaxTokens = *req.MaxTokens
+	}
+	if req.StopSequences != nil {
+		session.StopSequences = *req.StopSequences
+	}
+}
+
 // DeleteSession deletes a session
-func DeleteSession(db *gorm.DB) gin.HandlerFunc {
+func DeleteSession(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.Param("id")
 
-		// Check if session exists
-		var session models.Session
-		if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Session not found",
-				Message: "The specified session does not exist",
-				Code:    http.StatusNotFound,
-			})
+		if err := chat.DeleteSession(c.Request.Context(), currentUserID(c), sessionID); err != nil {
+			errResp := chatErrorResponse(err, "Failed to delete session")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Delete associated messages first
-		if err := db.Where("session_id = ?", sessionID).Delete(&models.Message{}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to delete session messages",
-				Code:    http.StatusInternalServerError,
-			})
+		c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
+	}
+}
+
+// ToggleFavorite toggles the favorite status of a session
+func ToggleFavorite(chat *services.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.Param("id")
+
+		session, err := chat.ToggleFavorite(c.Request.Context(), currentUserID(c), sessionID)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to update session")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		// Delete session
-		if err := db.Delete(&session).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to delete session",
-				Code:    http.StatusInternalServerError,
-			})
+		c.JSON(http.StatusOK, gin.H{
+			"session": session,
+			"message": "Favorite status updated",
+		})
+	}
+}
+
+// SwitchBranchRequest represents the request to switch the active branch of a session
+type SwitchBranchRequest struct {
+	MessageID string `json:"messageId" binding:"required"` // any message on the branch
+}
+
+// GetBranches lists the branches of a session's message tree
+func GetBranches(chat *services.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.Param("id")
+
+		branches, err := chat.ListBranches(c.Request.Context(), currentUserID(c), sessionID)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve branches")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"branches": branches})
 	}
 }
 
-// ToggleFavorite toggles the favorite status of a session
-func ToggleFavorite(db *gorm.DB) gin.HandlerFunc {
+// SwitchBranch makes the branch through a message the active one, following
+// the newest replies down from the message
+func SwitchBranch(chat *services.ChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.Param("id")
 
-		var session models.Session
-		if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{
-				Error:   "Session not found",
-				Message: "The specified session does not exist",
-				Code:    http.StatusNotFound,
+		var req SwitchBranchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
 			})
 			return
 		}
 
-		// Toggle favorite status
-		session.IsFavorite = !session.IsFavorite
-		session.UpdatedAt = time.Now()
-
-		if err := db.Save(&session).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error:   "Database error",
-				Message: "Failed to update session",
-				Code:    http.StatusInternalServerError,
-			})
+		messages, err := chat.SwitchBranch(c.Request.Context(), currentUserID(c), sessionID, req.MessageID)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to switch branch")
+			c.JSON(errResp.Code, *errResp)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"session": session,
-			"message": "Favorite status updated",
-		})
+		c.JSON(http.StatusOK, gin.H{"messages": messages})
 	}
 }
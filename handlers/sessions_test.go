@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSessionRouter serves the session and chat routes to the given user
+func newSessionRouter(t *testing.T, chat *services.ChatService, userID string) *gin.Engine {
+	hub, quotas := services.NewHub(), newTestQuotas(t)
+
+	router := gin.New()
+	api := router.Group("/api", asUser(userID))
+
+	sessions := api.Group("/sessions")
+	sessions.GET("/:id", GetSession(chat))
+	sessions.PUT("/:id", UpdateSession(chat))
+	sessions.DELETE("/:id", DeleteSession(chat))
+	sessions.POST("/:id/favorite", ToggleFavorite(chat))
+	sessions.GET("/:id/branches", GetBranches(chat))
+	sessions.PUT("/:id/branch", SwitchBranch(chat))
+
+	chatRoutes := api.Group("/chat")
+	chatRoutes.POST("/send", SendMessage(chat, hub, quotas))
+	chatRoutes.POST("/regenerate", RegenerateMessage(chat, hub, quotas))
+	chatRoutes.POST("/retry", RetryMessage(chat, hub, quotas))
+	chatRoutes.GET("/messages/:id", GetMessages(chat))
+	chatRoutes.PUT("/messages/:id", EditMessage(chat, hub, quotas))
+	chatRoutes.GET("/messages/:id/versions", GetVersions(chat))
+	chatRoutes.POST("/messages/:id/select", SelectVersion(chat))
+	chatRoutes.POST("/messages/:id/reactions", AddReaction(chat, hub))
+	chatRoutes.DELETE("/messages/:id/reactions", RemoveReaction(chat, hub))
+	return router
+}
+
+func TestOtherUsersSessionsAreNotFound(t *testing.T) {
+	ctx := context.Background()
+	chat := newTestChat(services.NewMockAIService())
+	alice := newSessionRouter(t, chat, "alice")
+	bob := newSessionRouter(t, chat, "bob")
+
+	var sent SendMessageResponse
+	if w := serveJSON(t, ctx, alice, http.MethodPost, "/api/chat/send", SendMessageRequest{Message: "Hi"}, &sent); w.Code != http.StatusOK {
+		t.Fatalf("send: status %d: %s", w.Code, w.Body)
+	}
+	sessionID, question, reply := sent.SessionID, sent.UserMessage.ID, sent.Message.ID
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodGet, "/api/sessions/" + sessionID, nil},
+		{http.MethodPut, "/api/sessions/" + sessionID, UpdateSessionRequest{Title: "Mine now"}},
+		{http.MethodDelete, "/api/sessions/" + sessionID, nil},
+		{http.MethodPost, "/api/sessions/" + sessionID + "/favorite", nil},
+		{http.MethodGet, "/api/sessions/" + sessionID + "/branches", nil},
+		{http.MethodPut, "/api/sessions/" + sessionID + "/branch", SwitchBranchRequest{MessageID: question}},
+		{http.MethodGet, "/api/chat/messages/" + sessionID, nil},
+		{http.MethodPut, "/api/chat/messages/" + question, EditMessageRequest{Message: "Edited"}},
+		{http.MethodPost, "/api/chat/regenerate", RegenerateMessageRequest{MessageID: reply, SessionID: sessionID}},
+		{http.MethodPost, "/api/chat/retry", RetryMessageRequest{MessageID: reply, SessionID: sessionID}},
+		{http.MethodGet, "/api/chat/messages/" + reply + "/versions", nil},
+		{http.MethodPost, "/api/chat/messages/" + reply + "/select", nil},
+		{http.MethodPost, "/api/chat/messages/" + reply + "/reactions", ReactionRequest{Emoji: "👍"}},
+		{http.MethodDelete, "/api/chat/messages/" + reply + "/reactions?emoji=%F0%9F%91%8D", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if w := serveJSON(t, ctx, bob, tt.method, tt.path, tt.body, nil); w.Code != http.StatusNotFound {
+				t.Errorf("status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+
+	// Nothing bob tried changed alice's session
+	var got struct {
+		Session models.Session `json:"session"`
+	}
+	if w := serveJSON(t, ctx, alice, http.MethodGet, "/api/sessions/"+sessionID, nil, &got); w.Code != http.StatusOK {
+		t.Fatalf("alice: status %d: %s", w.Code, w.Body)
+	}
+	if got.Session.Title == "Mine now" || got.Session.IsFavorite {
+		t.Errorf("alice's session was changed: %+v", got.Session)
+	}
+	messages := sessionMessages(t, chat, "alice")
+	if len(messages) != 2 {
+		t.Fatalf("alice has %d messages, want 2", len(messages))
+	}
+	for _, message := range messages {
+		if len(message.Reactions) > 0 || message.Content == "Edited" {
+			t.Errorf("message %s was changed: %+v", message.ID, message)
+		}
+	}
+}
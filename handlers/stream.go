@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"chatbot_backend/services"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errClientGone is returned from the delta callback when the client disconnects
+var errClientGone = errors.New("client disconnected")
+
+// StreamMessage handles sending a new message and streams the response as
+// Server-Sent Events. Events are "session" (the session and user message ids),
+// "delta" (a piece of the response), "message" (the saved bot message) and
+// "error". The bot message is saved as pending before the stream starts and
+// completed, or failed with the partial reply, when it ends; when the client
+// disconnects the AI request is cancelled and the partial reply is saved as
+// cancelled.
+func StreamMessage(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req SendMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		if errResp := checkProvider(chat, req.Provider); errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		if errResp := checkQuota(ctx, quotas, currentUserID(c)); errResp != nil {
+			writeErrorResponse(c, errResp)
+			return
+		}
+
+		exchange, err := chat.StartExchange(ctx, currentUserID(c), req.SessionID, req.Message)
+		if err != nil {
+			errResp := chatErrorResponse(err, "Failed to save user message")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+		session := exchange.Session
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.UserMessage})
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		c.SSEvent("session", gin.H{"sessionId": session.ID})
+		c.Writer.Flush()
+
+		// The bot message id is known up front so that other clients of the
+		// session can follow the deltas
+		botMessageID := exchange.Reply.ID
+		hub.Publish(session.ID, services.Event{
+			Type: services.EventTyping,
+			Data: services.TypingEvent{MessageID: botMessageID, Sender: "bot", IsTyping: true},
+		})
+		defer hub.Publish(session.ID, services.Event{
+			Type: services.EventTyping,
+			Data: services.TypingEvent{MessageID: botMessageID, Sender: "bot", IsTyping: false},
+		})
+
+		// Stream the AI response, stopping if the client goes away
+		completion, answeredBy, err := chat.Reply(ctx, &exchange, req.Provider, func(delta string) error {
+			hub.Publish(session.ID, services.Event{
+				Type: services.EventDelta,
+				Data: services.DeltaEvent{MessageID: botMessageID, Content: delta},
+			})
+			if ctx.Err() != nil {
+				return errClientGone
+			}
+			c.SSEvent("delta", gin.H{"content": delta})
+			c.Writer.Flush()
+			return nil
+		})
+
+		clientGone := errors.Is(err, errClientGone) || ctx.Err() != nil
+		if err != nil && !clientGone {
+			log.Printf("Session %s: AI service error: %v", session.ID, err)
+			c.SSEvent("error", *aiErrorResponse(err, "Failed to generate response"))
+			c.Writer.Flush()
+		}
+
+		// Keep recording the exchange now that the request context is done
+		if clientGone {
+			ctx = context.WithoutCancel(ctx)
+		}
+		quota := recordQuota(ctx, quotas, currentUserID(c), exchange.Reply.SessionID, completion)
+
+		// Keep whatever was streamed; fall back to the apology if nothing arrived
+		if err != nil && !clientGone {
+			content := completion.Content
+			if content == "" {
+				content = fallbackReply
+				answeredBy = fallbackProvider
+			}
+			err = chat.FailReply(ctx, &exchange, content, completion, answeredBy)
+		} else {
+			err = chat.CompleteReply(ctx, &exchange, completion, answeredBy, clientGone)
+		}
+		if err != nil {
+			log.Printf("Session %s: failed to save bot message: %v", session.ID, err)
+			if !clientGone {
+				c.SSEvent("error", ErrorResponse{
+					Error:   "Database error",
+					Message: "Failed to save bot message",
+					Code:    http.StatusInternalServerError,
+				})
+				c.Writer.Flush()
+			}
+			return
+		}
+
+		hub.Publish(session.ID, services.Event{Type: services.EventMessage, Data: exchange.Reply})
+
+		if !clientGone {
+			c.SSEvent("message", SendMessageResponse{
+				Message:     exchange.Reply,
+				UserMessage: exchange.UserMessage,
+				SessionID:   session.ID,
+				Quota:       quota,
+			})
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"chatbot_backend/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// usageDateLayout is the layout of the dates in usage requests and responses
+const usageDateLayout = "2006-01-02"
+
+// UsageTotals represents the tokens and cost of a group of AI responses.
+// TotalTokens and Cost include the overhead of summarizing older turns.
+type UsageTotals struct {
+	Messages         int     `json:"messages"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	OverheadTokens   int     `json:"overheadTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// DailyUsage represents the usage of a day (UTC)
+type DailyUsage struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+// SessionUsage represents the usage of a session. The title is empty once
+// the session is deleted.
+type SessionUsage struct {
+	SessionID string `json:"sessionId"`
+	Title     string `json:"title"`
+	UsageTotals
+}
+
+// ModelUsage represents the usage of a model
+type ModelUsage struct {
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// UsageResponse represents the usage of the current user over a date range
+type UsageResponse struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Total    UsageTotals    `json:"total"`
+	Days     []DailyUsage   `json:"days"`
+	Sessions []SessionUsage `json:"sessions"`
+	Models   []ModelUsage   `json:"models"`
+}
+
+// usageTotals selects the totals of a group of usage records
+const usageTotals = `COUNT(*) AS messages,
+	COALESCE(SUM(usage_records.prompt_tokens), 0) AS prompt_tokens,
+	COALESCE(SUM(usage_records.completion_tokens), 0) AS completion_tokens,
+	COALESCE(SUM(usage_records.overhead_tokens), 0) AS overhead_tokens,
+	COALESCE(SUM(usage_records.prompt_tokens + usage_records.completion_tokens + usage_records.overhead_tokens), 0) AS total_tokens,
+	COALESCE(SUM(usage_records.cost), 0) AS cost`
+
+// usageOrder sorts groups by cost, then tokens, both descending
+const usageOrder = "cost DESC, total_tokens DESC"
+
+// GetUsage reports the tokens and cost of the current user's AI responses
+// per day, session and model, as counted by their quota. The range is given
+// by month (YYYY-MM) or by from and to (YYYY-MM-DD, inclusive) and defaults
+// to the current month.
+func GetUsage(db *gorm.DB) gin.HandlerFunc {
+	// Usage records are stored in UTC
+	day := "strftime('%Y-%m-%d', usage_records.created_at)"
+	if db.Dialector.Name() == "postgres" {
+		day = "to_char(usage_records.created_at, 'YYYY-MM-DD')"
+	}
+
+	return func(c *gin.Context) {
+		from, to, errResp := usageRange(c.Query("month"), c.Query("from"), c.Query("to"), time.Now().UTC())
+		if errResp != nil {
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		records := func() *gorm.DB {
+			return db.WithContext(c.Request.Context()).Model(&models.UsageRecord{}).
+				Where("usage_records.user_id = ? AND usage_records.created_at >= ? AND usage_records.created_at < ?", currentUserID(c), from, to)
+		}
+		response := UsageResponse{
+			From:     from.Format(usageDateLayout),
+			To:       to.AddDate(0, 0, -1).Format(usageDateLayout),
+			Days:     []DailyUsage{},
+			Sessions: []SessionUsage{},
+			Models:   []ModelUsage{},
+		}
+
+		err := records().Select(usageTotals).Scan(&response.Total).Error
+		if err == nil {
+			err = records().Select(day + ` AS "date", ` + usageTotals).Group(day).Order(`"date"`).Scan(&response.Days).Error
+		}
+		if err == nil {
+			err = records().Select("usage_records.session_id, COALESCE(MAX(sessions.title), '') AS title, " + usageTotals).
+				Joins("LEFT JOIN sessions ON sessions.id = usage_records.session_id").
+				Group("usage_records.session_id").Order(usageOrder + ", usage_records.session_id").Scan(&response.Sessions).Error
+		}
+		if err == nil {
+			err = records().Select("usage_records.model, " + usageTotals).
+				Group("usage_records.model").Order(usageOrder + ", usage_records.model").Scan(&response.Models).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Database error",
+				Message: "Failed to retrieve usage",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// usageRange returns the start and the exclusive end of the requested range
+func usageRange(month, from, to string, now time.Time) (time.Time, time.Time, *ErrorResponse) {
+	invalid := func(message string) (time.Time, time.Time, *ErrorResponse) {
+		return time.Time{}, time.Time{}, &ErrorResponse{
+			Error:   "Invalid request",
+			Message: message,
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	if month != "" {
+		start, err := time.Parse("2006-01", month)
+		if err != nil {
+			return invalid("month must be formatted as YYYY-MM")
+		}
+		return start, start.AddDate(0, 1, 0), nil
+	}
+
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	if from != "" {
+		parsed, err := time.Parse(usageDateLayout, from)
+		if err != nil {
+			return invalid("from must be formatted as YYYY-MM-DD")
+		}
+		start = parsed
+	}
+	if to != "" {
+		parsed, err := time.Parse(usageDateLayout, to)
+		if err != nil {
+			return invalid("to must be formatted as YYYY-MM-DD")
+		}
+		end = parsed.AddDate(0, 0, 1)
+	}
+	if !start.Before(end) {
+		return invalid("from must not be after to")
+	}
+	return start, end, nil
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"chatbot_backend/config"
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetUsage(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	quotas := services.NewQuotaService(db, &config.Config{})
+	for _, session := range []models.Session{{ID: "session-1", UserID: "alice", Title: "Kept"}, {ID: "session-2", UserID: "alice", Title: "Deleted"}} {
+		if err := db.Create(&session).Error; err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+	}
+
+	for _, record := range []struct {
+		userID, sessionID string
+		completion        services.Completion
+	}{
+		{"alice", "session-1", services.Completion{Model: "gpt-4", Usage: services.Usage{PromptTokens: 100, CompletionTokens: 20}, Cost: 0.5}},
+		// The prompt of this reply was shortened by summarizing older turns
+		{"alice", "session-1", services.Completion{
+			Model:         "gpt-4",
+			Usage:         services.Usage{PromptTokens: 50, CompletionTokens: 10},
+			Cost:          0.25,
+			OverheadUsage: services.Usage{PromptTokens: 30, CompletionTokens: 5},
+			OverheadCost:  0.1,
+		}},
+		{"alice", "session-2", services.Completion{Model: "llama3", Usage: services.Usage{PromptTokens: 10, CompletionTokens: 2}}},
+		{"bob", "session-3", services.Completion{Model: "gpt-4", Usage: services.Usage{PromptTokens: 1000}, Cost: 5}},
+	} {
+		if _, err := quotas.Record(ctx, record.userID, record.sessionID, record.completion); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	// Deleting a session keeps the usage it was charged for
+	if err := db.Delete(&models.Session{ID: "session-2"}).Error; err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/api/usage", asUser("alice"), GetUsage(db))
+
+	var usage UsageResponse
+	if w := serveJSON(t, ctx, router, http.MethodGet, "/api/usage", nil, &usage); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/usage = %d: %s", w.Code, w.Body)
+	}
+
+	want := UsageTotals{Messages: 3, PromptTokens: 160, CompletionTokens: 32, OverheadTokens: 35, TotalTokens: 227, Cost: 0.85}
+	if usage.Total != want {
+		t.Errorf("total = %+v, want %+v", usage.Total, want)
+	}
+	if len(usage.Days) != 1 || usage.Days[0].Messages != 3 || usage.Days[0].Date < usage.From || usage.Days[0].Date > usage.To {
+		t.Errorf("days = %+v, want today within %s to %s", usage.Days, usage.From, usage.To)
+	}
+	if len(usage.Sessions) != 2 || usage.Sessions[0].SessionID != "session-1" || usage.Sessions[0].Title != "Kept" || usage.Sessions[0].TotalTokens != 215 {
+		t.Fatalf("sessions = %+v, want session-1 with 215 tokens first", usage.Sessions)
+	}
+	if deleted := usage.Sessions[1]; deleted.SessionID != "session-2" || deleted.Title != "" || deleted.TotalTokens != 12 {
+		t.Errorf("deleted session = %+v, want its 12 tokens without a title", deleted)
+	}
+	if len(usage.Models) != 2 || usage.Models[0].Model != "gpt-4" || usage.Models[1].Model != "llama3" {
+		t.Errorf("models = %+v, want gpt-4 then llama3", usage.Models)
+	}
+
+	if w := serveJSON(t, ctx, router, http.MethodGet, "/api/usage?month=2020-01", nil, &usage); w.Code != http.StatusOK || usage.Total.Messages != 0 || len(usage.Days) != 0 {
+		t.Errorf("GET an earlier month = %d with %+v, want no usage", w.Code, usage)
+	}
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"chatbot_backend/middleware"
+	"chatbot_backend/services"
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = (socketPongWait * 9) / 10
+	socketReadLimit  = 64 * 1024
+)
+
+// Request types sent by WebSocket clients
+const (
+	SocketRequestMessage    = "message"
+	SocketRequestRegenerate = "regenerate"
+	SocketRequestRetry      = "retry"
+	SocketRequestEdit       = "edit"
+	SocketRequestTyping     = "typing"
+)
+
+// SocketRequest represents a request sent by a WebSocket client
+type SocketRequest struct {
+	Type      string `json:"type"`
+	Content   string `json:"content,omitempty"`   // message, edit
+	MessageID string `json:"messageId,omitempty"` // regenerate, retry, edit
+	IsTyping  bool   `json:"isTyping,omitempty"`  // typing
+	Provider  string `json:"provider,omitempty"`  // message, regenerate, retry, edit
+}
+
+// upgrader accepts WebSocket connections from the origins allowed by CORS
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range middleware.GetCORSConfig().AllowOrigins {
+			if origin == allowed {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// ChatSocket handles the bidirectional WebSocket connection of a session.
+// Clients send SocketRequest frames; the server pushes services.Event frames
+// for every change in the session, including those made by other clients.
+// Frames that ask the AI service count against the chat rate limit, like the
+// chat routes.
+func ChatSocket(chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService, rateLimits middleware.RateLimitStore, chatLimit middleware.RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The request context is done once the connection closes, which
+		// cancels the AI requests still running for it
+		ctx := c.Request.Context()
+		userID := currentUserID(c)
+		sessionID := c.Param("sessionId")
+
+		if err := chat.CheckSession(ctx, userID, sessionID); err != nil {
+			errResp := chatErrorResponse(err, "Failed to retrieve session")
+			c.JSON(errResp.Code, *errResp)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Printf("WebSocket upgrade failed: %v", err)
+			return
+		}
+
+		events, unsubscribe := hub.Subscribe(sessionID)
+		direct := make(chan services.Event, 16)
+		done := make(chan struct{})
+
+		go writeSocket(conn, events, direct)
+
+		// reply sends an event to this connection only
+		reply := func(event services.Event) {
+			event.SessionID = sessionID
+			select {
+			case direct <- event:
+			case <-done:
+			}
+		}
+
+		defer func() {
+			close(done)
+			unsubscribe()
+			conn.Close()
+		}()
+
+		conn.SetReadLimit(socketReadLimit)
+		conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		})
+
+		for {
+			var req SocketRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.Printf("WebSocket read error: %v", err)
+				}
+				return
+			}
+
+			if errResp := checkProvider(chat, req.Provider); errResp != nil {
+				reply(services.Event{Type: services.EventError, Data: *errResp})
+				continue
+			}
+
+			switch req.Type {
+			case SocketRequestMessage, SocketRequestRegenerate, SocketRequestEdit, SocketRequestRetry:
+				if errResp := takeChatLimit(ctx, rateLimits, chatLimit, userID, c.ClientIP()); errResp != nil {
+					reply(services.Event{Type: services.EventError, Data: *errResp})
+					continue
+				}
+			}
+
+			switch req.Type {
+			case SocketRequestMessage:
+				if req.Content == "" {
+					reply(socketError(http.StatusBadRequest, "Invalid request", "Message content is required"))
+					continue
+				}
+				go socketExchange(ctx, chat, hub, quotas, userID, sessionID, req.Content, req.Provider, reply)
+			case SocketRequestRegenerate:
+				go func(messageID, providerName string) {
+					hub.Publish(sessionID, services.Event{
+						Type: services.EventTyping,
+						Data: services.TypingEvent{MessageID: messageID, Sender: "bot", IsTyping: true},
+					})
+					defer hub.Publish(sessionID, services.Event{
+						Type: services.EventTyping,
+						Data: services.TypingEvent{MessageID: messageID, Sender: "bot", IsTyping: false},
+					})
+
+					newMessage, quota, errResp := regenerateReply(ctx, chat, quotas, userID, sessionID, messageID, providerName)
+					if errResp != nil {
+						reply(services.Event{Type: services.EventError, Data: *errResp})
+						return
+					}
+					hub.Publish(sessionID, services.Event{Type: services.EventRegenerated, Data: newMessage})
+					if quota != nil && quota.Warning {
+						reply(services.Event{Type: services.EventQuota, Data: quota})
+					}
+				}(req.MessageID, req.Provider)
+			case SocketRequestEdit:
+				if req.Content == "" {
+					reply(socketError(http.StatusBadRequest, "Invalid request", "Message content is required"))
+					continue
+				}
+				go socketEdit(ctx, chat, hub, quotas, userID, req.MessageID, req.Content, req.Provider, reply)
+			case SocketRequestRetry:
+				go socketRetry(ctx, chat, hub, quotas, userID, sessionID, req.MessageID, req.Provider, reply)
+			case SocketRequestTyping:
+				hub.Publish(sessionID, services.Event{
+					Type: services.EventTyping,
+					Data: services.TypingEvent{Sender: "user", IsTyping: req.IsTyping},
+				})
+			default:
+				reply(socketError(http.StatusBadRequest, "Invalid request", "Unknown request type: "+req.Type))
+			}
+		}
+	}
+}
+
+// writeSocket writes session events and direct replies to the connection
+// and keeps it alive with pings. It returns when the hub channel is closed.
+func writeSocket(conn *websocket.Conn, events <-chan services.Event, direct <-chan services.Event) {
+	ticker := time.NewTicker(socketPingPeriod)
+	defer ticker.Stop()
+
+	write := func(event services.Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		return conn.WriteJSON(event) == nil
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if !write(event) {
+				conn.Close()
+				return
+			}
+		case event := <-direct:
+			if !write(event) {
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// socketExchange saves the user message with a pending bot message and
+// streams the reply to every client of the session
+func socketExchange(ctx context.Context, chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService, userID, sessionID, content, providerName string, reply func(services.Event)) {
+	if errResp := checkQuota(ctx, quotas, userID); errResp != nil {
+		reply(services.Event{Type: services.EventError, Data: *errResp})
+		return
+	}
+
+	exchange, err := chat.StartExchange(ctx, userID, sessionID, content)
+	if err != nil {
+		reply(services.Event{Type: services.EventError, Data: *chatErrorResponse(err, "Failed to save user message")})
+		return
+	}
+	hub.Publish(sessionID, services.Event{Type: services.EventMessage, Data: exchange.UserMessage})
+
+	socketReply(ctx, chat, hub, quotas, userID, &exchange, providerName, reply)
+}
+
+// socketEdit saves a new version of a user message, which starts a new
+// branch, and streams the reply to every client of the session
+func socketEdit(ctx context.Context, chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService, userID, messageID, content, providerName string, reply func(services.Event)) {
+	if errResp := checkQuota(ctx, quotas, userID); errResp != nil {
+		reply(services.Event{Type: services.EventError, Data: *errResp})
+		return
+	}
+
+	exchange, err := chat.EditMessage(ctx, userID, messageID, content)
+	if err != nil {
+		reply(services.Event{Type: services.EventError, Data: *chatErrorResponse(err, "Failed to save user message")})
+		return
+	}
+	hub.Publish(exchange.Session.ID, services.Event{Type: services.EventMessage, Data: exchange.UserMessage})
+
+	socketReply(ctx, chat, hub, quotas, userID, &exchange, providerName, reply)
+}
+
+// socketRetry answers a failed bot message again and streams the reply to
+// every client of the session
+func socketRetry(ctx context.Context, chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService, userID, sessionID, messageID, providerName string, reply func(services.Event)) {
+	if errResp := checkQuota(ctx, quotas, userID); errResp != nil {
+		reply(services.Event{Type: services.EventError, Data: *errResp})
+		return
+	}
+
+	exchange, err := chat.PrepareRetry(ctx, userID, sessionID, messageID)
+	if err != nil {
+		reply(services.Event{Type: services.EventError, Data: *chatErrorResponse(err, "Failed to load conversation history")})
+		return
+	}
+
+	socketReply(ctx, chat, hub, quotas, userID, &exchange, providerName, reply)
+}
+
+// socketReply streams the AI service's answer to the exchange's pending bot
+// message and saves it once complete, failed with the partial reply or an
+// apology, or cancelled if the connection closes first
+func socketReply(ctx context.Context, chat *services.ChatService, hub *services.Hub, quotas *services.QuotaService, userID string, exchange *services.Exchange, providerName string, reply func(services.Event)) {
+	sessionID := exchange.Session.ID
+	botMessageID := exchange.Reply.ID
+	hub.Publish(sessionID, services.Event{
+		Type: services.EventTyping,
+		Data: services.TypingEvent{MessageID: botMessageID, Sender: "bot", IsTyping: true},
+	})
+
+	completion, answeredBy, err := chat.Reply(ctx, exchange, providerName, func(delta string) error {
+		hub.Publish(sessionID, services.Event{
+			Type: services.EventDelta,
+			Data: services.DeltaEvent{MessageID: botMessageID, Content: delta},
+		})
+		return nil
+	})
+	cancelled := ctx.Err() != nil
+
+	// Keep recording the exchange once the connection has closed
+	if cancelled {
+		ctx = context.WithoutCancel(ctx)
+	}
+	quota := recordQuota(ctx, quotas, userID, sessionID, completion)
+
+	if err != nil && !cancelled {
+		log.Printf("Session %s: AI service error: %v", sessionID, err)
+		content := completion.Content
+		if content == "" {
+			content = fallbackReply
+			answeredBy = fallbackProvider
+		}
+		err = chat.FailReply(ctx, exchange, content, completion, answeredBy)
+	} else {
+		err = chat.CompleteReply(ctx, exchange, completion, answeredBy, cancelled)
+	}
+	if err != nil {
+		log.Printf("Session %s: failed to save bot message: %v", sessionID, err)
+		reply(socketError(http.StatusInternalServerError, "Database error", "Failed to save bot message"))
+	}
+
+	hub.Publish(sessionID, services.Event{
+		Type: services.EventTyping,
+		Data: services.TypingEvent{MessageID: botMessageID, Sender: "bot", IsTyping: false},
+	})
+	hub.Publish(sessionID, services.Event{Type: services.EventMessage, Data: exchange.Reply})
+	if quota != nil && quota.Warning {
+		reply(services.Event{Type: services.EventQuota, Data: quota})
+	}
+}
+
+// takeChatLimit takes a request from the chat rate limit of the user and
+// returns the error response to send if it is refused. Errors from the store
+// let the request through.
+func takeChatLimit(ctx context.Context, store middleware.RateLimitStore, limit middleware.RateLimit, userID, clientIP string) *ErrorResponse {
+	if !limit.Enabled() {
+		return nil
+	}
+
+	key := middleware.RateLimitKey("chat", userID, clientIP)
+	result, err := store.Take(ctx, key, limit)
+	if err != nil {
+		log.Printf("Rate limiter failed for %s: %v", key, err)
+		return nil
+	}
+	if result.Allowed {
+		return nil
+	}
+	return &ErrorResponse{
+		Error:      "Too many requests",
+		Message:    "Rate limit exceeded, please retry later",
+		Code:       http.StatusTooManyRequests,
+		RetryAfter: int(math.Ceil(result.RetryAfter.Seconds())),
+	}
+}
+
+// socketError builds an error event for a WebSocket client
+func socketError(code int, title, message string) services.Event {
+	return services.Event{
+		Type: services.EventError,
+		Data: ErrorResponse{
+			Error:   title,
+			Message: message,
+			Code:    code,
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"chatbot_backend/middleware"
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestChatSocketRateLimit(t *testing.T) {
+	ctx := context.Background()
+	chat := newTestChat(services.NewMockAIService())
+	session := models.Session{UserID: "alice", Title: "Test"}
+	if err := chat.CreateSession(ctx, &session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// The user already sent a chat request over HTTP, which leaves one of two
+	rateLimits := middleware.NewMemoryRateLimitStore()
+	limit := middleware.RateLimit{PerMinute: 1, Burst: 2}
+	if _, err := rateLimits.Take(ctx, middleware.RateLimitKey("chat", "alice", ""), limit); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/ws/chat/:sessionId", asUser("alice"), ChatSocket(chat, services.NewHub(), newTestQuotas(t), rateLimits, limit))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/chat/"+session.ID, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, req := range []SocketRequest{
+		{Type: SocketRequestMessage, Content: "Hi"},
+		{Type: SocketRequestTyping, IsTyping: true},
+		{Type: SocketRequestMessage, Content: "Hi again"},
+	} {
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+	}
+
+	// Wait for the refusal and for the answer to the first message
+	var refusals []ErrorResponse
+	answered := false
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(refusals) == 0 || !answered {
+		var event struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON: %v (refusals %+v, answered %v)", err, refusals, answered)
+		}
+		switch event.Type {
+		case services.EventError:
+			var errResp ErrorResponse
+			json.Unmarshal(event.Data, &errResp)
+			refusals = append(refusals, errResp)
+		case services.EventMessage:
+			var message models.Message
+			json.Unmarshal(event.Data, &message)
+			answered = answered || (message.Sender == "bot" && message.Status == models.MessageStatusCompleted)
+		}
+	}
+
+	if len(refusals) != 1 || refusals[0].Code != http.StatusTooManyRequests || refusals[0].RetryAfter <= 0 {
+		t.Fatalf("refusals = %+v, want one 429 with a retry delay", refusals)
+	}
+	if messages := sessionMessages(t, chat, "alice"); len(messages) != 2 || messages[0].Content != "Hi" {
+		t.Errorf("got %d messages, want only the first message and its answer", len(messages))
+	}
+}
@@ -4,14 +4,23 @@ import (
 	"chatbot_backend/config"
 	"chatbot_backend/handlers"
 	"chatbot_backend/middleware"
+	"chatbot_backend/migrations"
+	"chatbot_backend/models"
+	"chatbot_backend/repository"
 	"chatbot_backend/services"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -24,14 +33,39 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	// Run the migrate subcommand instead of the server if requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
+	}
+
 	// Initialize database
-	db := initDB()
+	db := initDB(cfg)
+	assignSessionOwner(db, cfg.DefaultSessionOwner)
+	promoteAdmins(db, cfg.AdminEmails)
+
+	// Initialize AI providers
+	registry := initProviders(cfg)
+
+	// Initialize context builder
+	contextBuilder := services.NewContextBuilder(cfg.ContextTokenBudget)
+
+	// Initialize chat service
+	chatService := services.NewChatService(repository.NewGorm(db), registry, contextBuilder)
+
+	// Initialize realtime hub
+	hub := services.NewHub()
 
-	// Initialize AI service
-	aiService := initAIService(cfg)
+	// Initialize authentication
+	tokens := initAuth(cfg)
+	apiKeys := services.NewAPIKeyService(db)
+
+	// Initialize quotas, resetting them when a new month starts
+	quotas := services.NewQuotaService(db, cfg)
+	quotas.StartResetSchedule(context.Background(), time.Hour)
 
 	// Initialize router
-	r := setupRouter(cfg, db, aiService)
+	r := setupRouter(cfg, db, registry, chatService, hub, tokens, apiKeys, quotas)
 
 	// Start server
 	log.Printf("Starting server on port %s", cfg.Port)
@@ -41,7 +75,35 @@ func main() {
 }
 
 // initDB initializes the database connection and runs migrations
-func initDB() *gorm.DB {
+func initDB(cfg *config.Config) *gorm.DB {
+	db := openDB(cfg)
+	migrateDB(db, cfg.AutoMigrate)
+
+	log.Println("Database initialized successfully")
+	return db
+}
+
+// openDB connects to the database selected by DB_DRIVER
+func openDB(cfg *config.Config) *gorm.DB {
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case "postgres":
+		dialector = postgres.Open(postgresDSN())
+	case "sqlite":
+		dialector = sqlite.Open(sqliteDSN(cfg.DBPath))
+	default:
+		log.Fatalf("Unknown database driver %q, expected postgres or sqlite", cfg.DBDriver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	return db
+}
+
+// postgresDSN builds the Postgres connection string from the environment
+func postgresDSN() string {
 	// Get database configuration from environment
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
@@ -49,40 +111,154 @@ func initDB() *gorm.DB {
 	dbPassword := getEnv("DB_PASSWORD", "password")
 	dbName := getEnv("DB_NAME", "chatbot")
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// sqliteDSN returns the connection string of a SQLite database file. Foreign
+// keys are enforced so deletes cascade as they do on Postgres, and writers
+// wait for the lock instead of failing while another write is in progress.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL"
+}
+
+// migrateDB applies the pending migrations, or only verifies the schema when
+// automatic migrations are disabled. The server refuses to start on a dirty,
+// modified or outdated schema.
+func migrateDB(db *gorm.DB, auto bool) {
+	migrator, err := migrations.New(db)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to load migrations:", err)
 	}
+	ctx := context.Background()
 
-	// Check and create tables if they don't exist
-	createTablesIfNotExist(db)
+	if !auto {
+		if err := migrator.Check(ctx); err != nil {
+			log.Fatal("Database schema is not usable:", err)
+		}
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			log.Fatal("Failed to check migrations:", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database schema has %d pending migrations, run \"migrate up\" first", len(pending))
+		}
+		return
+	}
 
-	log.Println("Database initialized successfully")
-	return db
+	applied, err := migrator.Up(ctx)
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+}
+
+// assignSessionOwner gives the sessions created before user accounts existed
+// to the configured default owner, identified by user ID or email
+func assignSessionOwner(db *gorm.DB, owner string) {
+	var orphans int64
+	if err := db.Model(&models.Session{}).Where("user_id IS NULL").Count(&orphans).Error; err != nil {
+		log.Fatal("Failed to count sessions without owner:", err)
+	}
+	if orphans == 0 {
+		return
+	}
+
+	if owner == "" {
+		log.Printf("Warning: %d sessions have no owner, set DEFAULT_SESSION_OWNER to assign them", orphans)
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, "id = ? OR email = ?", owner, strings.ToLower(owner)).Error; err != nil {
+		log.Printf("Warning: default session owner %q not found, %d sessions have no owner", owner, orphans)
+		return
+	}
+
+	result := db.Model(&models.Session{}).Where("user_id IS NULL").Update("user_id", user.ID)
+	if result.Error != nil {
+		log.Fatal("Failed to assign sessions to the default owner:", result.Error)
+	}
+	log.Printf("Assigned %d sessions to %s", result.RowsAffected, user.Email)
+}
+
+// promoteAdmins gives the admin role to the users with the configured emails
+func promoteAdmins(db *gorm.DB, emails []string) {
+	if len(emails) == 0 {
+		return
+	}
+
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = strings.ToLower(email)
+	}
+
+	result := db.Model(&models.User{}).
+		Where("email IN ? AND role <> ?", normalized, models.RoleAdmin).
+		Update("role", models.RoleAdmin)
+	if result.Error != nil {
+		log.Fatal("Failed to promote admins:", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Gave the admin role to %d users", result.RowsAffected)
+	}
 }
 
-// initAIService initializes the AI service
-func initAIService(cfg *config.Config) services.AIService {
-	if cfg.AIAPIKey == "" {
+// initProviders initializes the configured AI providers
+func initProviders(cfg *config.Config) *services.Registry {
+	if cfg.AIAPIKey == "" && len(cfg.Providers) == 1 && cfg.Providers[0].Type == services.ProviderMock {
 		log.Println("No AI API key provided, using mock service")
-		return services.NewMockAIService()
 	}
 
-	log.Println("Initializing OpenAI service")
-	return services.NewOpenAIService()
+	registry, err := services.NewRegistryFromConfig(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize AI providers:", err)
+	}
+
+	for _, provider := range registry.List() {
+		log.Printf("Registered AI provider %s (%s)", provider.Name, provider.Type)
+	}
+	log.Printf("Default AI provider: %s", registry.DefaultName())
+	return registry
+}
+
+// initAuth initializes the token manager used to authenticate users
+func initAuth(cfg *config.Config) *services.TokenManager {
+	secret := cfg.JWTSecret
+	if secret == "" {
+		if cfg.IsProduction() {
+			log.Fatal("JWT_SECRET must be set in production")
+		}
+
+		// Tokens signed with a random secret do not survive a restart
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatal("Failed to generate JWT secret:", err)
+		}
+		secret = hex.EncodeToString(buf)
+		log.Println("No JWT_SECRET provided, using a random secret")
+	}
+
+	return services.NewTokenManager(secret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
 }
 
 // setupRouter configures and returns the Gin router
-func setupRouter(cfg *config.Config, db *gorm.DB, aiService services.AIService) *gin.Engine {
+func setupRouter(cfg *config.Config, db *gorm.DB, registry *services.Registry, chatService *services.ChatService, hub *services.Hub, tokens *services.TokenManager, apiKeys *services.APIKeyService, quotas *services.QuotaService) *gin.Engine {
 	// Set Gin mode based on environment
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Add middleware
 	r.Use(middleware.LoggingMiddleware())
@@ -91,136 +267,106 @@ func setupRouter(cfg *config.Config, db *gorm.DB, aiService services.AIService)
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
+		providers := registry.Health()
+
+		// The service is degraded when every provider's breaker is open
+		status := "degraded"
+		for _, provider := range providers {
+			if provider.Breaker.State != services.BreakerOpen {
+				status = "ok"
+				break
+			}
+		}
+
 		c.JSON(200, gin.H{
-			"status":      "ok",
+			"status":      status,
 			"service":     "chatbot-backend",
 			"version":     "1.0.0",
 			"environment": cfg.Environment,
+			"providers":   providers,
 		})
 	})
 
 	// Setup API routes
-	setupRoutes(r, db, aiService)
+	setupRoutes(r, cfg, db, registry, chatService, hub, tokens, apiKeys, quotas)
 
 	return r
 }
 
 // setupRoutes configures all API routes
-func setupRoutes(r *gin.Engine, db *gorm.DB, aiService services.AIService) {
+func setupRoutes(r *gin.Engine, cfg *config.Config, db *gorm.DB, registry *services.Registry, chatService *services.ChatService, hub *services.Hub, tokens *services.TokenManager, apiKeys *services.APIKeyService, quotas *services.QuotaService) {
 	api := r.Group("/api")
+	auth := middleware.NewAuthMiddleware(tokens, apiKeys)
+
+	// Requests calling the AI are limited separately from cheap reads
+	rateLimits := middleware.NewMemoryRateLimitStore()
+	chatRate := middleware.RateLimit{PerMinute: cfg.ChatRateLimit, Burst: cfg.ChatRateBurst}
+	chatLimit := middleware.RateLimitMiddleware(rateLimits, "chat", chatRate)
+	readLimit := middleware.RateLimitMiddleware(rateLimits, "read", middleware.RateLimit{PerMinute: cfg.ReadRateLimit, Burst: cfg.ReadRateBurst})
+
+	// Auth routes
+	authRoutes := api.Group("/auth", readLimit)
+	authRoutes.POST("/register", handlers.Register(db, tokens))
+	authRoutes.POST("/login", handlers.Login(db, tokens))
+	authRoutes.POST("/refresh", handlers.Refresh(db, tokens))
+	authRoutes.POST("/logout", handlers.Logout(db))
+
+	// API key routes; keys cannot be used to manage keys
+	keys := api.Group("/keys", auth.RequireAuth(), auth.RequireLogin(), readLimit)
+	keys.GET("", handlers.GetAPIKeys(db))
+	keys.POST("", handlers.CreateAPIKey(db))
+	keys.DELETE("/:id", handlers.RevokeAPIKey(db))
 
 	// Chat routes
-	chat := api.Group("/chat")
-	chat.POST("/send", handlers.SendMessage(db, aiService))
-	chat.POST("/regenerate", handlers.RegenerateMessage(db, aiService))
-	chat.GET("/messages/:id", handlers.GetMessages(db))
+	chat := api.Group("/chat", auth.RequireAuth(), auth.RequireScopes(services.ScopeSessionsRead, services.ScopeChatWrite))
+	chat.POST("/send", chatLimit, handlers.SendMessage(chatService, hub, quotas))
+	chat.POST("/stream", chatLimit, handlers.StreamMessage(chatService, hub, quotas))
+	chat.POST("/regenerate", chatLimit, handlers.RegenerateMessage(chatService, hub, quotas))
+	chat.POST("/retry", chatLimit, handlers.RetryMessage(chatService, hub, quotas))
+	chat.GET("/messages/:id", readLimit, handlers.GetMessages(chatService))
+	chat.PUT("/messages/:id", chatLimit, handlers.EditMessage(chatService, hub, quotas))
+	chat.GET("/messages/:id/versions", readLimit, handlers.GetVersions(chatService))
+	chat.POST("/messages/:id/select", readLimit, handlers.SelectVersion(chatService))
+	chat.POST("/messages/:id/reactions", readLimit, handlers.AddReaction(chatService, hub))
+	chat.DELETE("/messages/:id/reactions", readLimit, handlers.RemoveReaction(chatService, hub))
 
 	// Session routes
-	sessions := api.Group("/sessions")
-	sessions.GET("", handlers.GetSessions(db))
-	sessions.POST("", handlers.CreateSession(db))
-	sessions.GET("/:id", handlers.GetSession(db))
-	sessions.PUT("/:id", handlers.UpdateSession(db))
-	sessions.DELETE("/:id", handlers.DeleteSession(db))
-	sessions.POST("/:id/favorite", handlers.ToggleFavorite(db))
-
-	// WebSocket endpoint (placeholder for future implementation)
-	r.GET("/ws/chat/:sessionId", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message":   "WebSocket endpoint - not implemented yet",
-			"sessionId": c.Param("sessionId"),
-		})
-	})
-}
-
-// createTablesIfNotExist creates tables if they don't exist
-func createTablesIfNotExist(db *gorm.DB) {
-	// Check if sessions table exists
-	if !db.Migrator().HasTable("sessions") {
-		log.Println("Creating sessions table...")
-		if err := db.Exec(`
-			CREATE TABLE sessions (
-				id VARCHAR(255) PRIMARY KEY,
-				title VARCHAR(255) NOT NULL,
-				created_at TIMESTAMP NOT NULL,
-				updated_at TIMESTAMP NOT NULL,
-				is_favorite BOOLEAN DEFAULT FALSE
-			)
-		`).Error; err != nil {
-			log.Fatal("Failed to create sessions table:", err)
-		}
-		log.Println("Sessions table created successfully")
-	}
-
-	// Check if messages table exists
-	if !db.Migrator().HasTable("messages") {
-		log.Println("Creating messages table...")
-		if err := db.Exec(`
-			CREATE TABLE messages (
-				id VARCHAR(255) PRIMARY KEY,
-				content TEXT NOT NULL,
-				sender VARCHAR(50) NOT NULL CHECK (sender IN ('user', 'bot')),
-				timestamp TIMESTAMP NOT NULL,
-				message_type VARCHAR(50) DEFAULT 'text',
-				is_typing BOOLEAN DEFAULT FALSE,
-				is_favorite BOOLEAN DEFAULT FALSE,
-				is_regenerated BOOLEAN DEFAULT FALSE,
-				original_message_id VARCHAR(255),
-				session_id VARCHAR(255) NOT NULL,
-				language VARCHAR(10),
-				code_block BOOLEAN DEFAULT FALSE,
-				link_title VARCHAR(255),
-				link_description TEXT,
-				link_image VARCHAR(500),
-				link_url VARCHAR(500),
-				link_domain VARCHAR(255),
-				FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
-			)
-		`).Error; err != nil {
-			log.Fatal("Failed to create messages table:", err)
-		}
-		log.Println("Messages table created successfully")
-	}
-
-	// Check if reactions table exists
-	if !db.Migrator().HasTable("reactions") {
-		log.Println("Creating reactions table...")
-		if err := db.Exec(`
-			CREATE TABLE reactions (
-				id VARCHAR(255) PRIMARY KEY,
-				emoji VARCHAR(10) NOT NULL,
-				count INTEGER DEFAULT 0,
-				users TEXT,
-				message_id VARCHAR(255) NOT NULL,
-				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
-			)
-		`).Error; err != nil {
-			log.Fatal("Failed to create reactions table:", err)
-		}
-		log.Println("Reactions table created successfully")
-	}
-
-	// Create indexes if they don't exist
-	createIndexesIfNotExist(db)
-}
-
-// createIndexesIfNotExist creates indexes for better performance
-func createIndexesIfNotExist(db *gorm.DB) {
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_messages_session_id ON messages(session_id)",
-		"CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp)",
-		"CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender)",
-		"CREATE INDEX IF NOT EXISTS idx_sessions_updated_at ON sessions(updated_at)",
-		"CREATE INDEX IF NOT EXISTS idx_sessions_is_favorite ON sessions(is_favorite)",
-		"CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id)",
-	}
-
-	for _, indexSQL := range indexes {
-		if err := db.Exec(indexSQL).Error; err != nil {
-			log.Printf("Warning: Failed to create index: %v", err)
-		}
-	}
-	log.Println("Database indexes created/verified")
+	sessions := api.Group("/sessions", auth.RequireAuth(), auth.RequireScopes(services.ScopeSessionsRead, services.ScopeSessionsWrite), readLimit)
+	sessions.GET("", handlers.GetSessions(chatService))
+	sessions.POST("", handlers.CreateSession(chatService))
+	sessions.GET("/:id", handlers.GetSession(chatService))
+	sessions.PUT("/:id", handlers.UpdateSession(chatService))
+	sessions.DELETE("/:id", handlers.DeleteSession(chatService))
+	sessions.POST("/:id/favorite", handlers.ToggleFavorite(chatService))
+	sessions.GET("/:id/branches", handlers.GetBranches(chatService))
+	sessions.PUT("/:id/branch", handlers.SwitchBranch(chatService))
+
+	// Persona routes
+	personas := api.Group("/personas", auth.RequireAuth(), auth.RequireScopes(services.ScopePersonasRead, services.ScopePersonasWrite), readLimit)
+	personas.GET("", handlers.GetPersonas(db))
+	personas.POST("", handlers.CreatePersona(db, registry))
+	personas.GET("/:id", handlers.GetPersona(db))
+	personas.PUT("/:id", handlers.UpdatePersona(db, registry))
+	personas.DELETE("/:id", handlers.DeletePersona(db))
+
+	// Search route
+	api.GET("/search", auth.RequireAuth(), auth.RequireScope(services.ScopeSessionsRead), readLimit, handlers.Search(chatService))
+
+	// Usage routes
+	api.GET("/usage", auth.RequireAuth(), auth.RequireScope(services.ScopeUsageRead), readLimit, handlers.GetUsage(db))
+	api.GET("/quota", auth.RequireAuth(), auth.RequireScope(services.ScopeUsageRead), readLimit, handlers.GetQuota(quotas))
+
+	// Admin routes
+	admin := api.Group("/admin", auth.RequireAuth(), auth.RequireLogin(), auth.RequireAdmin(), readLimit)
+	admin.GET("/users/:id/quota", handlers.GetUserQuota(db, quotas))
+	admin.PUT("/users/:id/quota", handlers.UpdateUserQuota(db, quotas))
+	admin.POST("/users/:id/quota/reset", handlers.ResetUserQuota(db, quotas))
+
+	// Provider routes
+	api.GET("/providers", auth.RequireAuth(), readLimit, handlers.GetProviders(registry))
+
+	// WebSocket endpoint
+	r.GET("/ws/chat/:sessionId", auth.RequireAuth(), auth.RequireScope(services.ScopeChatWrite), readLimit, handlers.ChatSocket(chatService, hub, quotas, rateLimits, chatRate))
 }
 
 // getEnv gets an environment variable with a default value
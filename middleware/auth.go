@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"chatbot_backend/models"
+	"chatbot_backend/services"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -8,16 +11,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware provides basic authentication middleware
-// For this chatbot application, we'll implement a simple token-based auth
+// AuthMiddleware authenticates requests with the access tokens issued at
+// login or with personal API keys
 type AuthMiddleware struct {
-	// In a real application, you would validate tokens against a database
-	// or JWT service. For now, we'll use a simple approach.
+	tokens  *services.TokenManager
+	apiKeys *services.APIKeyService
 }
 
 // NewAuthMiddleware creates a new auth middleware instance
-func NewAuthMiddleware() *AuthMiddleware {
-	return &AuthMiddleware{}
+func NewAuthMiddleware(tokens *services.TokenManager, apiKeys *services.APIKeyService) *AuthMiddleware {
+	return &AuthMiddleware{tokens: tokens, apiKeys: apiKeys}
 }
 
 // RequireAuth middleware that requires authentication
@@ -25,6 +28,12 @@ func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && isWebSocketRequest(c) {
+			// Browsers cannot set headers on WebSocket connections
+			if token := c.Query("token"); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Unauthorized",
@@ -58,33 +67,144 @@ func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Validate the token (simplified for demo purposes)
-		if !a.validateToken(token) {
+		// API keys are looked up; access tokens are verified
+		if services.IsAPIKey(token) {
+			apiKey, err := a.apiKeys.Authenticate(c.Request.Context(), token)
+			if err != nil {
+				if !errors.Is(err, services.ErrInvalidAPIKey) {
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"error":   "Internal error",
+						"message": "Failed to verify API key",
+						"code":    http.StatusInternalServerError,
+					})
+					c.Abort()
+					return
+				}
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error":   "Unauthorized",
+					"message": "Invalid API key",
+					"code":    http.StatusUnauthorized,
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("user_id", apiKey.UserID)
+			c.Set("api_key", apiKey)
+			c.Next()
+			return
+		}
+
+		// Validate the token
+		claims, err := a.validateToken(token)
+		if err != nil {
+			message := "Invalid token"
+			if errors.Is(err, services.ErrTokenExpired) {
+				message = "Token has expired"
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Unauthorized",
-				"message": "Invalid token",
+				"message": message,
 				"code":    http.StatusUnauthorized,
 			})
 			c.Abort()
 			return
 		}
 
-		// Set user info in context (you would typically decode JWT or lookup user)
-		c.Set("user_id", "demo_user")
+		// Set user info in context
+		c.Set("user_id", claims.Subject)
+		c.Set("role", claims.Role)
 		c.Set("token", token)
 
 		c.Next()
 	}
 }
 
+// RequireScope rejects requests made with an API key that lacks the scope.
+// Access tokens act for the user and are not limited by scopes.
+func (a *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey, ok := APIKeyFromContext(c); ok && !services.HasScope(apiKey, scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"message": "API key is missing the " + scope + " scope",
+				"code":    http.StatusForbidden,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopes requires the read scope for GET and HEAD requests and the
+// write scope for any other method, for route groups that do both
+func (a *AuthMiddleware) RequireScopes(read, write string) gin.HandlerFunc {
+	requireRead := a.RequireScope(read)
+	requireWrite := a.RequireScope(write)
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			requireRead(c)
+			return
+		}
+		requireWrite(c)
+	}
+}
+
+// RequireLogin rejects requests made with an API key, for routes only a
+// logged in user may use, such as managing the API keys themselves
+func (a *AuthMiddleware) RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := APIKeyFromContext(c); ok {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"message": "This endpoint cannot be used with an API key",
+				"code":    http.StatusForbidden,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects requests from users without the admin role. The role
+// is taken from the access token, so it must follow RequireAuth and
+// RequireLogin.
+func (a *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != models.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"message": "Admin access required",
+				"code":    http.StatusForbidden,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// APIKeyFromContext returns the API key that authenticated the request, if any
+func APIKeyFromContext(c *gin.Context) (*models.APIKey, bool) {
+	value, ok := c.Get("api_key")
+	if !ok {
+		return nil, false
+	}
+	apiKey, ok := value.(*models.APIKey)
+	return apiKey, ok
+}
+
 // OptionalAuth middleware that optionally validates authentication
 func (a *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if a.validateToken(token) {
-				c.Set("user_id", "demo_user")
+			if claims, err := a.validateToken(token); err == nil {
+				c.Set("user_id", claims.Subject)
+				c.Set("role", claims.Role)
 				c.Set("token", token)
 			}
 		}
@@ -92,29 +212,15 @@ func (a *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 	}
 }
 
-// validateToken validates the provided token
-// In a real application, this would validate against a database or JWT
-func (a *AuthMiddleware) validateToken(token string) bool {
-	// For demo purposes, accept any non-empty token
-	// In production, you would:
-	// 1. Validate JWT signature
-	// 2. Check token expiration
-	// 3. Verify token against database
-	// 4. Check user permissions
-
-	// Simple validation for demo
-	return len(token) > 10
+// validateToken verifies the signature and expiry of an access token and
+// returns its claims
+func (a *AuthMiddleware) validateToken(token string) (*services.Claims, error) {
+	return a.tokens.ParseAccessToken(token)
 }
 
-// RateLimitMiddleware provides basic rate limiting
-func RateLimitMiddleware() gin.HandlerFunc {
-	// This is a simplified rate limiter
-	// In production, you would use Redis or a proper rate limiting library
-	return func(c *gin.Context) {
-		// For now, just pass through
-		// You could implement IP-based rate limiting here
-		c.Next()
-	}
+// isWebSocketRequest reports whether the request is a WebSocket handshake
+func isWebSocketRequest(c *gin.Context) bool {
+	return strings.EqualFold(c.GetHeader("Upgrade"), "websocket")
 }
 
 // LoggingMiddleware provides request logging
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket: it holds up to Burst requests and refills at
+// PerMinute requests per minute
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Enabled reports whether the limit restricts anything
+func (l RateLimit) Enabled() bool {
+	return l.PerMinute > 0 && l.Burst > 0
+}
+
+// rate returns the refill rate in requests per second
+func (l RateLimit) rate() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// RateLimitResult is the outcome of taking a request from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until the next request is allowed, when denied
+	Reset      time.Duration // until the bucket is full again
+}
+
+// RateLimitStore keeps the token buckets. The in-memory store only limits a
+// single instance; deployments running several instances plug in a shared
+// store instead.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// bucket is the state of a token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// refill adds the tokens gained since the bucket was last updated
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.rate())
+	b.updated = now
+}
+
+// memorySweepInterval is how often full buckets are dropped from memory
+const memorySweepInterval = time.Minute
+
+// MemoryRateLimitStore keeps token buckets in memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now returns the current time; tests replace it to control refills
+	now func() time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a request from the bucket for the key
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	result := RateLimitResult{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / limit.rate())
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((float64(limit.Burst) - b.tokens) / limit.rate())
+	return result, nil
+}
+
+// sweep drops the buckets that have refilled completely, since they are
+// the same as a new bucket
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware limits requests per user, or per client IP for
+// requests without a user. Requests limited under different names use
+// separate buckets. Errors from the store let the request through.
+func RateLimitMiddleware(store RateLimitStore, name string, limit RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limit.Enabled() {
+			c.Next()
+			return
+		}
+
+		key := RateLimitKey(name, c.GetString("user_id"), c.ClientIP())
+		result, err := store.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			log.Printf("Rate limiter failed for %s: %v", key, err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":      "Too many requests",
+				"message":    "Rate limit exceeded, please retry later",
+				"code":       http.StatusTooManyRequests,
+				"retryAfter": retryAfter,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitKey returns the key of the bucket limiting a user's requests under
+// the name, or a client IP's for requests without a user
+func RateLimitKey(name, userID, clientIP string) string {
+	if userID != "" {
+		return name + ":user:" + userID
+	}
+	return name + ":ip:" + clientIP
+}
+
+// secondsToDuration converts fractional seconds to a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
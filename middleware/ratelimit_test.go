@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestStore returns a memory store whose clock only moves when the
+// returned function is called
+func newTestStore() (*MemoryRateLimitStore, func(time.Duration)) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	return store, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store, advance := newTestStore()
+	limit := RateLimit{PerMinute: 6, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take(ctx, "key", limit); !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", i+1, result, 1-i)
+		}
+	}
+	result, _ := store.Take(ctx, "key", limit)
+	if result.Allowed || result.RetryAfter != 10*time.Second {
+		t.Fatalf("request over the burst: %+v, want denied for 10s", result)
+	}
+
+	// Other keys have buckets of their own
+	if result, _ := store.Take(ctx, "other", limit); !result.Allowed {
+		t.Fatalf("other key: %+v, want allowed", result)
+	}
+
+	// The bucket refills one request every 10 seconds
+	advance(9 * time.Second)
+	if result, _ := store.Take(ctx, "key", limit); result.Allowed {
+		t.Fatalf("request after 9s: %+v, want denied", result)
+	}
+	advance(time.Second)
+	if result, _ := store.Take(ctx, "key", limit); !result.Allowed {
+		t.Fatalf("request after 10s: %+v, want allowed", result)
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store, advance := newTestStore()
+	limit := RateLimit{PerMinute: 60, Burst: 5}
+
+	store.Take(ctx, "idle", limit)
+	advance(2 * memorySweepInterval)
+	store.Take(ctx, "busy", limit)
+
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("the refilled bucket was kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("the bucket in use was dropped")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, advance := newTestStore()
+	limit := RateLimit{PerMinute: 30, Burst: 1}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if userID := c.GetHeader("X-User"); userID != "" {
+			c.Set("user_id", userID)
+		}
+	})
+	router.GET("/", RateLimitMiddleware(store, "chat", limit), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	get := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("alice"); w.Code != http.StatusNoContent || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: status %d, headers %v", w.Code, w.Header())
+	}
+	w := get("alice")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("second request: status %d, Retry-After %q; want 429 after 2s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Users and anonymous clients are limited separately
+	if w := get("bob"); w.Code != http.StatusNoContent {
+		t.Errorf("other user: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := get(""); w.Code != http.StatusNoContent {
+		t.Errorf("anonymous client: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	advance(2 * time.Second)
+	if w := get("alice"); w.Code != http.StatusNoContent {
+		t.Errorf("request after Retry-After: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestRateLimitMiddlewareForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, _ := newTestStore()
+
+	// Without trusted proxies, X-Forwarded-For does not change the client IP
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	router.POST("/login", RateLimitMiddleware(store, "auth", RateLimit{PerMinute: 5, Burst: 1}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for i, forwardedFor := range []string{"203.0.113.1", "203.0.113.2"} {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if want := []int{http.StatusNoContent, http.StatusTooManyRequests}[i]; w.Code != want {
+			t.Fatalf("request from %s: status %d, want %d", forwardedFor, w.Code, want)
+		}
+	}
+}
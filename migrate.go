@@ -0,0 +1,97 @@
+package main
+
+import (
+	"chatbot_backend/config"
+	"chatbot_backend/migrations"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// migrateUsage describes the migrate subcommand
+const migrateUsage = `Usage: main migrate <command>
+
+Commands:
+  up             apply all pending migrations
+  down [N]       roll back the last N migrations (default 1)
+  status         list the migrations and whether they are applied
+  force VERSION  record the schema as being at VERSION without running
+                 migrations, after a dirty schema was repaired by hand`
+
+// runMigrate runs the migrate subcommand
+func runMigrate(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	migrator, err := migrations.New(openDB(cfg))
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of migrations %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal("Failed to roll back database:", err)
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("No applied migrations")
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal("Failed to read migrations:", err)
+		}
+		for _, status := range statuses {
+			appliedAt := "-"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %-9s %s\n", status.Version, status.Name, status.State, appliedAt)
+		}
+
+	case "force":
+		if len(args) < 2 {
+			log.Fatal("migrate force needs a version")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid version %q", args[1])
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			log.Fatal("Failed to force version:", err)
+		}
+		fmt.Printf("Schema recorded at version %d\n", version)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,392 @@
+// Package migrations applies the versioned database schema. Each version is
+// a pair of NNNN_name.up.sql and NNNN_name.down.sql files embedded in the
+// binary, in a directory per database dialect; the versions applied to a
+// database are recorded in the schema_migrations table together with the
+// checksum of their up script.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Errors returned when the schema cannot be migrated safely
+var (
+	ErrDirty            = errors.New("schema is dirty")
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+)
+
+// fileName matches migration files such as 0001_initial_schema.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a version of the schema
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// Record is a row of the schema_migrations table. A dirty record was being
+// applied or rolled back when the migrator stopped.
+type Record struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	Checksum  string    `gorm:"not null"`
+	Dirty     bool      `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName specifies the table name for Record
+func (Record) TableName() string {
+	return "schema_migrations"
+}
+
+// Status describes a migration and whether it is applied
+type Status struct {
+	Version   int64
+	Name      string
+	State     string // "applied", "pending", "dirty", "modified" or "unknown"
+	AppliedAt *time.Time
+}
+
+// Load reads the migrations in fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files named %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations on a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New creates a migrator with the migrations embedded in the binary for the
+// database's dialect
+func New(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	if dialect != "postgres" && dialect != "sqlite" {
+		return nil, fmt.Errorf("no migrations for the %s dialect", dialect)
+	}
+	fsys, err := fs.Sub(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Pending returns the migrations that are not applied yet, in order
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Check returns an error if the schema may not be used: a migration is
+// dirty, was changed after it was applied, or is unknown to this binary
+func (m *Migrator) Check(ctx context.Context) error {
+	records, err := m.records(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Dirty {
+			return fmt.Errorf("%w: migration %04d_%s did not finish; repair the schema and run \"migrate force\"", ErrDirty, record.Version, record.Name)
+		}
+		migration, ok := m.find(record.Version)
+		if !ok {
+			return fmt.Errorf("%w: migration %04d_%s is applied but not known to this binary", ErrUnknownVersion, record.Version, record.Name)
+		}
+		if migration.Checksum != record.Checksum {
+			return fmt.Errorf("%w: migration %04d_%s was changed after it was applied", ErrChecksumMismatch, record.Version, record.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies the pending migrations in order and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.Check(ctx); err != nil {
+		return nil, err
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range pending {
+		if err := m.apply(ctx, migration); err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of most recent migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := m.Check(ctx); err != nil {
+		return nil, err
+	}
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(records) - 1; i >= 0 && len(done) < steps; i-- {
+		migration, _ := m.find(records[i].Version)
+		if err := m.rollback(ctx, migration); err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Status lists the known migrations and the applied ones unknown to this
+// binary, ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]Record, len(records))
+	for _, record := range records {
+		byVersion[record.Version] = record
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name, State: "pending"}
+		if record, ok := byVersion[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+			switch {
+			case record.Dirty:
+				status.State = "dirty"
+			case record.Checksum != migration.Checksum:
+				status.State = "modified"
+			default:
+				status.State = "applied"
+			}
+			delete(byVersion, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range byVersion {
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, Status{Version: record.Version, Name: record.Name, State: "unknown", AppliedAt: &appliedAt})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Force records the schema as being at the given version without running
+// any migration: newer records are removed and the known migrations up to
+// the version are recorded as applied with their current checksum. It is
+// used after a dirty schema has been repaired by hand; version 0 clears
+// every record.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("version > ?", version).Delete(&Record{}).Error; err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			record := Record{Version: migration.Version, Name: migration.Name, Checksum: migration.Checksum, AppliedAt: time.Now()}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "version"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name", "checksum", "dirty"}),
+			}).Create(&record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// apply runs a migration's up script. The record is marked dirty first, so
+// that a migrator stopped before the transaction commits leaves a trace;
+// a failed script is rolled back and its record removed again.
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	db := m.db.WithContext(ctx)
+	record := Record{Version: migration.Version, Name: migration.Name, Checksum: migration.Checksum, Dirty: true, AppliedAt: time.Now()}
+	if err := db.Create(&record).Error; err != nil {
+		return err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+		return tx.Model(&record).Updates(map[string]interface{}{"dirty": false, "applied_at": time.Now()}).Error
+	})
+	if err != nil {
+		// The schema is unchanged, so the migration may simply be retried
+		if cleanupErr := db.Delete(&record).Error; cleanupErr != nil {
+			return errors.Join(err, cleanupErr)
+		}
+	}
+	return err
+}
+
+// rollback runs a migration's down script and removes its record
+func (m *Migrator) rollback(ctx context.Context, migration Migration) error {
+	db := m.db.WithContext(ctx)
+	record := Record{Version: migration.Version}
+	if err := db.Model(&record).Update("dirty", true).Error; err != nil {
+		return err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&record).Error
+	})
+	if err != nil {
+		if cleanupErr := db.Model(&record).Update("dirty", false).Error; cleanupErr != nil {
+			return errors.Join(err, cleanupErr)
+		}
+	}
+	return err
+}
+
+// records returns the applied migrations ordered by version
+func (m *Migrator) records(ctx context.Context) ([]Record, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	var records []Record
+	err := m.db.WithContext(ctx).Order("version").Find(&records).Error
+	return records, err
+}
+
+// applied returns the set of applied versions
+func (m *Migrator) applied(ctx context.Context) (map[int64]bool, error) {
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// ensureTable creates the schema_migrations table if needed
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	return m.db.WithContext(ctx).Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum VARCHAR(64) NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`).Error
+}
+
+// find returns the known migration with the version
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
@@ -0,0 +1,253 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestMigrator returns a migrator with the embedded SQLite migrations on
+// an empty database
+func newTestMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	return migrator, db
+}
+
+// states returns the state of each migration by version
+func states(t *testing.T, migrator *Migrator) map[int64]string {
+	t.Helper()
+
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	states := make(map[int64]string, len(statuses))
+	for _, status := range statuses {
+		states[status.Version] = status.State
+	}
+	return states
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"0002_add_index.up.sql":   {Data: []byte("CREATE INDEX idx ON a(id);")},
+		"0002_add_index.down.sql": {Data: []byte("DROP INDEX idx;")},
+		"0001_create_a.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_create_a.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"README.md":               {Data: []byte("Not a migration")},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "add_index" {
+		t.Fatalf("migrations = %+v, want create_a and add_index in order", migrations)
+	}
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("checksums %q and %q, want one per up script", migrations[0].Checksum, migrations[1].Checksum)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing down script": {"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (id INT);")}},
+		"mismatched names": {
+			"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+			"0001_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		},
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	migrator, db := newTestMigrator(t)
+	latest := migrator.migrations[len(migrator.migrations)-1]
+
+	done, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(done) != len(migrator.migrations) {
+		t.Fatalf("applied %d migrations, want all %d", len(done), len(migrator.migrations))
+	}
+	for version, state := range states(t, migrator) {
+		if state != "applied" {
+			t.Errorf("migration %d is %s, want applied", version, state)
+		}
+	}
+	if !db.Migrator().HasTable("sessions") {
+		t.Fatal("the schema was not created")
+	}
+
+	if done, err := migrator.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second Up = %d migrations, %v; want none", len(done), err)
+	}
+
+	done, err = migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(done) != 1 || done[0].Version != latest.Version {
+		t.Fatalf("rolled back %+v, want only migration %d", done, latest.Version)
+	}
+	if state := states(t, migrator)[latest.Version]; state != "pending" {
+		t.Errorf("rolled back migration is %s, want pending", state)
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil || len(pending) != 1 || pending[0].Version != latest.Version {
+		t.Fatalf("Pending = %+v, %v; want the rolled back migration", pending, err)
+	}
+	if done, err := migrator.Up(ctx); err != nil || len(done) != 1 {
+		t.Fatalf("Up after Down = %d migrations, %v; want the rolled back one", len(done), err)
+	}
+
+	// Rolling back every migration drops the schema
+	if done, err := migrator.Down(ctx, len(migrator.migrations)); err != nil || len(done) != len(migrator.migrations) {
+		t.Fatalf("Down all = %d migrations, %v; want all %d", len(done), err, len(migrator.migrations))
+	}
+	if db.Migrator().HasTable("sessions") {
+		t.Error("the down scripts did not drop the schema")
+	}
+}
+
+func TestMigratorChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	migrator, db := newTestMigrator(t)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// The first migration was edited after it was applied
+	if err := db.Model(&Record{}).Where("version = ?", 1).Update("checksum", "tampered").Error; err != nil {
+		t.Fatalf("tamper with checksum: %v", err)
+	}
+
+	if err := migrator.Check(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Check = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := migrator.Up(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Up = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Down = %v, want ErrChecksumMismatch", err)
+	}
+	if state := states(t, migrator)[1]; state != "modified" {
+		t.Errorf("tampered migration is %s, want modified", state)
+	}
+}
+
+func TestMigratorDirty(t *testing.T) {
+	ctx := context.Background()
+	migrator, db := newTestMigrator(t)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	latest := migrator.migrations[len(migrator.migrations)-1]
+
+	// The migrator stopped while applying the latest migration
+	if err := db.Model(&Record{}).Where("version = ?", latest.Version).Update("dirty", true).Error; err != nil {
+		t.Fatalf("mark dirty: %v", err)
+	}
+
+	if _, err := migrator.Up(ctx); !errors.Is(err, ErrDirty) {
+		t.Errorf("Up = %v, want ErrDirty", err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, ErrDirty) {
+		t.Errorf("Down = %v, want ErrDirty", err)
+	}
+	if state := states(t, migrator)[latest.Version]; state != "dirty" {
+		t.Errorf("interrupted migration is %s, want dirty", state)
+	}
+
+	// Once the schema is repaired, force records it as clean
+	if err := migrator.Force(ctx, latest.Version); err != nil {
+		t.Fatalf("Force: %v", err)
+	}
+	if err := migrator.Check(ctx); err != nil {
+		t.Errorf("Check after Force = %v, want nil", err)
+	}
+}
+
+func TestMigratorForce(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := newTestMigrator(t)
+
+	if err := migrator.Force(ctx, 2); err != nil {
+		t.Fatalf("Force: %v", err)
+	}
+	states := states(t, migrator)
+	if states[1] != "applied" || states[2] != "applied" || states[3] != "pending" {
+		t.Errorf("states = %v, want migrations 1 and 2 recorded as applied", states)
+	}
+
+	if err := migrator.Force(ctx, 99); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Force to an unknown version = %v, want ErrUnknownVersion", err)
+	}
+	if err := migrator.Force(ctx, 0); err != nil {
+		t.Fatalf("Force to 0: %v", err)
+	}
+	if pending, err := migrator.Pending(ctx); err != nil || len(pending) != len(migrator.migrations) {
+		t.Errorf("Pending after Force to 0 = %d, %v; want every migration", len(pending), err)
+	}
+}
+
+func TestMigratorUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	migrator, db := newTestMigrator(t)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// A newer binary applied a migration this one does not know
+	if err := db.Create(&Record{Version: 9999, Name: "from_the_future", Checksum: "x"}).Error; err != nil {
+		t.Fatalf("create record: %v", err)
+	}
+	if err := migrator.Check(ctx); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Check = %v, want ErrUnknownVersion", err)
+	}
+	if state := states(t, migrator)[9999]; state != "unknown" {
+		t.Errorf("migration 9999 is %s, want unknown", state)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	_, db := newTestMigrator(t)
+	migrator := &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "create_a", Up: "CREATE TABLE a (id INT);", Down: "DROP TABLE a;", Checksum: "1"},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE b (id INT); NOT SQL;", Down: "DROP TABLE b;", Checksum: "2"},
+	}}
+
+	done, err := migrator.Up(ctx)
+	if err == nil || len(done) != 1 {
+		t.Fatalf("Up = %d migrations, %v; want the first one and an error", len(done), err)
+	}
+
+	// The failed migration left neither a record nor a dirty flag behind
+	states := states(t, migrator)
+	if states[1] != "applied" || states[2] != "pending" {
+		t.Errorf("states = %v, want the failed migration pending", states)
+	}
+	if err := migrator.Check(ctx); err != nil {
+		t.Errorf("Check = %v, want the failed migration to be retryable", err)
+	}
+}
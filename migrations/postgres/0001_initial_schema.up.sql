@@ -0,0 +1,161 @@
+-- 0001 İlk şema
+-- Tüm tablolar IF NOT EXISTS ile oluşturulur; böylece migration sisteminden
+-- önce createTablesIfNotExist ile kurulmuş veritabanları da bu sürümü
+-- sorunsuz uygular.
+
+-- 0a. Users Tablosu
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(255) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    name VARCHAR(100),
+    password_hash VARCHAR(255) NOT NULL, -- bcrypt
+    role VARCHAR(20) NOT NULL DEFAULT 'user', -- 'user' veya 'admin'
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- 0b. Refresh Tokens Tablosu (sadece token hash'i saklanır)
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP, -- rotasyon veya çıkışta doldurulur
+    replaced_by VARCHAR(255),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 0c. API Keys Tablosu (kişisel API anahtarları, sadece hash saklanır)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL, -- listelemede gösterilen ilk karakterler
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL, -- JSON dizi: ["chat:write", "sessions:read"]
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP, -- NULL ise süresiz
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 0d. User Quotas Tablosu (aylık token ve harcama limitleri)
+CREATE TABLE IF NOT EXISTS user_quotas (
+    user_id VARCHAR(255) PRIMARY KEY,
+    plan VARCHAR(50), -- boşsa varsayılan plan
+    token_limit BIGINT, -- planın limitini geçersiz kılar, 0 sınırsız
+    cost_limit DOUBLE PRECISION, -- USD, planın limitini geçersiz kılar, 0 sınırsız
+    period_start TIMESTAMP NOT NULL, -- içinde bulunulan ayın başı (UTC)
+    tokens_used BIGINT NOT NULL DEFAULT 0,
+    cost_used DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 0e. Personas Tablosu
+CREATE TABLE IF NOT EXISTS personas (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    system_prompt TEXT NOT NULL,
+    avatar VARCHAR(500),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    provider VARCHAR(100),
+    model VARCHAR(100),
+    temperature DOUBLE PRECISION,
+    top_p DOUBLE PRECISION,
+    max_tokens INTEGER,
+    stop_sequences TEXT -- JSON array as string
+);
+
+-- 1. Sessions Tablosu
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE, -- oturumun sahibi
+    title VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    is_favorite BOOLEAN DEFAULT FALSE,
+    provider VARCHAR(100), -- boşsa varsayılan AI sağlayıcısı
+    model VARCHAR(100),
+    temperature DOUBLE PRECISION,
+    top_p DOUBLE PRECISION,
+    max_tokens INTEGER,
+    stop_sequences TEXT, -- JSON array as string
+    persona_id VARCHAR(255) REFERENCES personas(id) ON DELETE SET NULL,
+    system_prompt TEXT, -- personanın sistem mesajını geçersiz kılar
+    summary TEXT, -- AI context penceresine sığmayan eski mesajların özeti
+    summarized_until TIMESTAMP
+);
+
+-- 2. Messages Tablosu
+CREATE TABLE IF NOT EXISTS messages (
+    id VARCHAR(255) PRIMARY KEY,
+    content TEXT NOT NULL,
+    sender VARCHAR(50) NOT NULL CHECK (sender IN ('user', 'bot')),
+    timestamp TIMESTAMP NOT NULL,
+    message_type VARCHAR(50) DEFAULT 'text',
+    is_typing BOOLEAN DEFAULT FALSE,
+    is_favorite BOOLEAN DEFAULT FALSE,
+    is_regenerated BOOLEAN DEFAULT FALSE,
+    is_cancelled BOOLEAN DEFAULT FALSE, -- kullanıcı yanıt tamamlanmadan ayrıldı
+    original_message_id VARCHAR(255),
+    provider VARCHAR(100), -- yanıtı veren AI sağlayıcısı
+    model VARCHAR(100), -- yanıtı veren model
+    prompt_tokens INTEGER DEFAULT 0,
+    completion_tokens INTEGER DEFAULT 0,
+    cost DOUBLE PRECISION DEFAULT 0, -- USD, fiyat tablosuna göre
+    session_id VARCHAR(255) NOT NULL,
+    language VARCHAR(10),
+    code_block BOOLEAN DEFAULT FALSE,
+    link_title VARCHAR(255),
+    link_description TEXT,
+    link_image VARCHAR(500),
+    link_url VARCHAR(500),
+    link_domain VARCHAR(255),
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- 3. Reactions Tablosu
+CREATE TABLE IF NOT EXISTS reactions (
+    id VARCHAR(255) PRIMARY KEY,
+    emoji VARCHAR(10) NOT NULL,
+    count INTEGER DEFAULT 0,
+    users TEXT, -- JSON array as string
+    message_id VARCHAR(255) NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+-- Eski sürümlerin oluşturduğu tablolarda eksik olan kolonlar
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS summary TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS summarized_until TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS provider VARCHAR(100);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS model VARCHAR(100);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS top_p DOUBLE PRECISION;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS max_tokens INTEGER;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS stop_sequences TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS persona_id VARCHAR(255) REFERENCES personas(id) ON DELETE SET NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS system_prompt TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS provider VARCHAR(100);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_cancelled BOOLEAN DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS model VARCHAR(100);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS prompt_tokens INTEGER DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS completion_tokens INTEGER DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS cost DOUBLE PRECISION DEFAULT 0;
+
+-- 4. Performans için İndeksler
+CREATE INDEX IF NOT EXISTS idx_messages_session_id ON messages(session_id);
+CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender);
+CREATE INDEX IF NOT EXISTS idx_sessions_updated_at ON sessions(updated_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_is_favorite ON sessions(is_favorite);
+CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_persona_id ON sessions(persona_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,21 @@
+ALTER TABLE reactions RENAME TO reactions_normalized;
+ALTER INDEX IF EXISTS reactions_pkey RENAME TO reactions_normalized_pkey;
+
+CREATE TABLE reactions (
+    id VARCHAR(255) PRIMARY KEY,
+    emoji VARCHAR(10) NOT NULL,
+    count INTEGER DEFAULT 0,
+    users TEXT, -- JSON array as string
+    message_id VARCHAR(255) NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+INSERT INTO reactions (id, emoji, count, users, message_id)
+SELECT MIN(id), emoji, COUNT(*), json_agg(user_id ORDER BY created_at)::text, message_id
+FROM reactions_normalized
+WHERE char_length(emoji) <= 10
+GROUP BY message_id, emoji;
+
+DROP TABLE reactions_normalized;
+
+CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id);
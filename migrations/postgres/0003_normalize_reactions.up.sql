@@ -0,0 +1,30 @@
+-- 0003 Tepkilerin normalleştirilmesi
+-- Her (mesaj, kullanıcı, emoji) için tek satır tutulur; sayılar okunurken
+-- hesaplanır. Eşzamanlı tepkiler aynı satırı güncellemediği için kaybolmaz.
+-- Eski satırlardaki JSON kullanıcı listesi satırlara açılır.
+
+ALTER TABLE reactions RENAME TO reactions_legacy;
+ALTER INDEX IF EXISTS reactions_pkey RENAME TO reactions_legacy_pkey;
+
+CREATE TABLE reactions (
+    id VARCHAR(255) PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    emoji VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO reactions (id, message_id, user_id, emoji, created_at)
+SELECT DISTINCT ON (r.message_id, u.id, r.emoji) gen_random_uuid()::text, r.message_id, u.id, r.emoji, CURRENT_TIMESTAMP
+FROM reactions_legacy r
+CROSS JOIN LATERAL jsonb_array_elements_text(
+    CASE WHEN r.users LIKE '[%' THEN r.users::jsonb ELSE '[]'::jsonb END
+) AS legacy(user_id)
+JOIN users u ON u.id = legacy.user_id;
+
+DROP TABLE reactions_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id);
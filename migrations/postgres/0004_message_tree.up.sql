@@ -0,0 +1,26 @@
+-- 0004 Mesaj ağacı
+-- Her mesaj dalındaki bir önceki mesajı gösterir; kullanıcı mesajını
+-- düzenlemek yeni bir kardeş mesaj, yani yeni bir dal oluşturur. Oturum,
+-- aktif dalın son mesajını tutar.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE;
+-- Aktif mesaj silinirse aktif dal en yeni mesajdan devam eder
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS active_message_id VARCHAR(255);
+
+-- Mevcut oturumlar tek daldan oluşur: her mesajın ebeveyni bir önceki mesajdır
+UPDATE messages SET parent_id = (
+    SELECT p.id FROM messages p
+    WHERE p.session_id = messages.session_id
+      AND (p.timestamp < messages.timestamp OR (p.timestamp = messages.timestamp AND p.id < messages.id))
+    ORDER BY p.timestamp DESC, p.id DESC
+    LIMIT 1
+);
+
+UPDATE sessions SET active_message_id = (
+    SELECT m.id FROM messages m
+    WHERE m.session_id = sessions.id
+    ORDER BY m.timestamp DESC, m.id DESC
+    LIMIT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id);
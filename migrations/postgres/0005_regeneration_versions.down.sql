@@ -0,0 +1,3 @@
+-- Sürümler kardeş olarak kalır; yalnızca veri taşındığı için geri alınacak
+-- şema değişikliği yoktur
+SELECT 1;
@@ -0,0 +1,23 @@
+-- 0005 Yeniden üretilen yanıtların sürümleri
+-- Eski sürümler yeniden üretilen yanıtı oturumun sonuna ekliyor ve asıl
+-- yanıtın original_message_id alanını kendisine yönlendiriyordu. Yeniden
+-- üretilen yanıtlar, asıl yanıtın cevapladığı kullanıcı mesajının altına
+-- kardeş sürüm olarak taşınır.
+
+UPDATE messages SET parent_id = COALESCE((
+    SELECT u.id FROM messages u, messages o
+    WHERE o.id = messages.original_message_id
+      AND u.session_id = messages.session_id
+      AND u.sender = 'user'
+      AND u.timestamp < o.timestamp
+    ORDER BY u.timestamp DESC, u.id DESC
+    LIMIT 1
+), parent_id)
+WHERE is_regenerated
+  AND original_message_id IS NOT NULL
+  AND original_message_id <> ''
+  AND original_message_id <> id;
+
+-- Asıl yanıtlar yeniden üretilmiş sayılmaz
+UPDATE messages SET is_regenerated = FALSE, original_message_id = ''
+WHERE original_message_id = id;
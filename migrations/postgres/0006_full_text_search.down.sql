@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_sessions_search_vector;
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE sessions DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
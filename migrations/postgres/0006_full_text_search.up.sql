@@ -0,0 +1,16 @@
+-- 0006 Tam metin arama
+-- Mesaj içerikleri ve oturum başlıkları hem Türkçe hem İngilizce olarak
+-- indekslenir; sorgu iki dilde de eşleşen kayıtları bulur.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        to_tsvector('turkish', COALESCE(content, '')) || to_tsvector('english', COALESCE(content, ''))
+    ) STORED;
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        to_tsvector('turkish', COALESCE(title, '')) || to_tsvector('english', COALESCE(title, ''))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_sessions_search_vector ON sessions USING GIN (search_vector);
@@ -0,0 +1,9 @@
+-- 0007 Persona sahipliği
+-- Kullanıcıların oluşturduğu personalar yalnızca sahiplerine görünür ve
+-- yalnızca sahipleri tarafından değiştirilebilir. Sahibi olmayan personalar
+-- herkesle paylaşılır ve yalnızca yöneticiler tarafından değiştirilir; mevcut
+-- personalar paylaşılan olarak kalır.
+
+ALTER TABLE personas ADD COLUMN IF NOT EXISTS user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_personas_user_id ON personas(user_id);
@@ -0,0 +1,26 @@
+-- 0009 Kullanım kayıtları
+-- Her AI yanıtının token ve maliyeti, istemi hazırlayan özetleme istekleri
+-- dahil olmak üzere ayrı bir kayıtta tutulur. Kayıtlar oturum silindiğinde
+-- silinmez; böylece raporlanan kullanım kotaların saydığıyla aynı kalır.
+-- Mevcut bot mesajlarının kullanımı kayıtlara aktarılır.
+
+CREATE TABLE IF NOT EXISTS usage_records (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id VARCHAR(255) NOT NULL, -- oturum silinse de kalır
+    model VARCHAR(100) NOT NULL DEFAULT '',
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    overhead_tokens INTEGER NOT NULL DEFAULT 0, -- istemi hazırlayan özetleme
+    cost DOUBLE PRECISION NOT NULL DEFAULT 0, -- USD, özetleme dahil
+    created_at TIMESTAMP NOT NULL -- UTC
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_records_user_created ON usage_records(user_id, created_at);
+
+INSERT INTO usage_records (id, user_id, session_id, model, prompt_tokens, completion_tokens, cost, created_at)
+SELECT m.id, s.user_id, m.session_id, COALESCE(m.model, ''), COALESCE(m.prompt_tokens, 0),
+    COALESCE(m.completion_tokens, 0), COALESCE(m.cost, 0), m.timestamp
+FROM messages m JOIN sessions s ON s.id = m.session_id
+WHERE m.sender = 'bot' AND s.user_id IS NOT NULL
+  AND (m.prompt_tokens > 0 OR m.completion_tokens > 0 OR m.cost > 0);
@@ -0,0 +1,10 @@
+-- 0001 İlk şema: tüm tabloları bağımlılık sırasının tersine kaldırır
+
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS personas;
+DROP TABLE IF EXISTS user_quotas;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,6 @@
+-- 0002 Mesaj durumu
+-- Bot yanıtı AI servisi çalışırken pending olarak kaydedilir, ardından
+-- completed veya failed olur. Mevcut mesajlar tamamlanmış sayılır.
+
+ALTER TABLE messages ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'completed'
+    CHECK (status IN ('pending', 'completed', 'failed'));
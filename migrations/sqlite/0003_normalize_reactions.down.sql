@@ -0,0 +1,19 @@
+ALTER TABLE reactions RENAME TO reactions_normalized;
+
+CREATE TABLE reactions (
+    id VARCHAR(255) PRIMARY KEY,
+    emoji VARCHAR(10) NOT NULL,
+    count INTEGER DEFAULT 0,
+    users TEXT, -- JSON array as string
+    message_id VARCHAR(255) NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+INSERT INTO reactions (id, emoji, count, users, message_id)
+SELECT MIN(id), emoji, COUNT(*), json_group_array(user_id), message_id
+FROM reactions_normalized
+GROUP BY message_id, emoji;
+
+DROP TABLE reactions_normalized;
+
+CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id);
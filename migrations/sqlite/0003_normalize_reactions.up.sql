@@ -0,0 +1,27 @@
+-- 0003 Tepkilerin normalleştirilmesi
+-- Her (mesaj, kullanıcı, emoji) için tek satır tutulur; sayılar okunurken
+-- hesaplanır. Eşzamanlı tepkiler aynı satırı güncellemediği için kaybolmaz.
+-- Eski satırlardaki JSON kullanıcı listesi satırlara açılır.
+
+ALTER TABLE reactions RENAME TO reactions_legacy;
+
+CREATE TABLE reactions (
+    id VARCHAR(255) PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    emoji VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT OR IGNORE INTO reactions (id, message_id, user_id, emoji, created_at)
+SELECT lower(hex(randomblob(16))), r.message_id, u.id, r.emoji, CURRENT_TIMESTAMP
+FROM reactions_legacy r, json_each(r.users) AS legacy
+JOIN users u ON u.id = legacy.value
+WHERE json_valid(r.users) AND json_type(r.users) = 'array';
+
+DROP TABLE reactions_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_reactions_message_id ON reactions(message_id);
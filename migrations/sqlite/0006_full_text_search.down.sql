@@ -0,0 +1,2 @@
+-- Geri alınacak şema değişikliği yoktur
+SELECT 1;
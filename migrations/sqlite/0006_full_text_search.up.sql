@@ -0,0 +1,4 @@
+-- 0006 Tam metin arama
+-- Tam metin arama PostgreSQL'e özgüdür. SQLite'ta arama, mesaj içeriklerini
+-- ve oturum başlıklarını LIKE ile tarar; indekslenecek bir sütun yoktur.
+SELECT 1;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_personas_user_id;
+ALTER TABLE personas DROP COLUMN user_id;
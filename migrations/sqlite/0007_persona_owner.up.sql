@@ -0,0 +1,11 @@
+-- 0007 Persona sahipliği
+-- Kullanıcıların oluşturduğu personalar yalnızca sahiplerine görünür ve
+-- yalnızca sahipleri tarafından değiştirilebilir. Sahibi olmayan personalar
+-- herkesle paylaşılır ve yalnızca yöneticiler tarafından değiştirilir; mevcut
+-- personalar paylaşılan olarak kalır.
+
+-- SQLite yabancı anahtarda kullanılan kolonu silemediği için user_id
+-- kısıtsızdır
+ALTER TABLE personas ADD COLUMN user_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_personas_user_id ON personas(user_id);
@@ -0,0 +1,5 @@
+-- 0008 Mesaj sayfaları için indeks
+-- Mesajlar oturum içinde zamana ve kimliğe göre sayfalanır; sayfa sorgusu
+-- imleç koşulunu ve sınırı bu indeksle veritabanında uygular.
+
+CREATE INDEX IF NOT EXISTS idx_messages_session_timestamp ON messages(session_id, timestamp, id);
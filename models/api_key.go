@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey represents a personal API key for programmatic access.
+// Only the hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     StringList `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// TableName returns the table name of API keys
+func (APIKey) TableName() string {
+	return "api_keys"
+}
@@ -4,7 +4,10 @@ import (
 	"time"
 )
 
-// Message represents a chat message
+// Message represents a chat message. The messages of a session form a tree:
+// editing a user message or regenerating a reply adds a sibling, which
+// starts a new branch of the conversation. Siblings from the same sender are
+// the numbered versions of one turn.
 type Message struct {
 	ID                string     `json:"id" gorm:"primaryKey"`
 	Content           string     `json:"content"`
@@ -13,17 +16,66 @@ type Message struct {
 	MessageType       string     `json:"messageType"` // "text" | "code" | "image" | "link"
 	IsTyping          bool       `json:"isTyping"`
 	IsFavorite        bool       `json:"isFavorite"`
-	IsRegenerated     bool       `json:"isRegenerated"`
-	OriginalMessageID string     `json:"originalMessageId,omitempty"`
+	IsRegenerated     bool       `json:"isRegenerated"`               // a later version of a bot reply
+	IsCancelled       bool       `json:"isCancelled"`                 // the client aborted before the reply completed
+	Status            string     `json:"status"`                      // "pending" | "completed" | "failed"
+	OriginalMessageID string     `json:"originalMessageId,omitempty"` // the first version, for regenerated replies
+	Provider          string     `json:"provider,omitempty"`          // the AI provider that answered
+	Model             string     `json:"model,omitempty"`             // the model that answered
+	PromptTokens      int        `json:"promptTokens,omitempty"`
+	CompletionTokens  int        `json:"completionTokens,omitempty"`
+	Cost              float64    `json:"cost,omitempty"` // USD, from the configured price table
 	SessionID         string     `json:"sessionId"`
+	ParentID          *string    `json:"parentId,omitempty"` // the previous message on the branch, nil for the first
 	Reactions         []Reaction `json:"reactions" gorm:"foreignKey:MessageID"`
+
+	// Position among the versions of the turn and their number; computed
+	// when messages are listed
+	Version      int `json:"version,omitempty" gorm:"-"`
+	VersionCount int `json:"versionCount,omitempty" gorm:"-"`
 }
 
-// Reaction represents a message reaction
+// Message statuses. A bot reply is pending while the AI service works on it
+// and then completed, or failed if the AI service could not answer; failed
+// replies can be retried. User messages are always completed.
+const (
+	MessageStatusPending   = "pending"
+	MessageStatusCompleted = "completed"
+	MessageStatusFailed    = "failed"
+)
+
+// Reaction is a user's emoji reaction to a message. A user reacts to a
+// message with each emoji at most once; counts are computed from the rows
+// with CountReactions.
 type Reaction struct {
-	ID        string `json:"id" gorm:"primaryKey"`
-	Emoji     string `json:"emoji"`
-	Count     int    `json:"count"`
-	Users     string `json:"users"` // JSON array as string
-	MessageID string `json:"messageId"`
+	ID        string    `json:"id" gorm:"primaryKey"`
+	MessageID string    `json:"messageId"`
+	UserID    string    `json:"userId"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReactionCount is the number of users who reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// CountReactions groups reactions by emoji, in the order each emoji was
+// first used
+func CountReactions(reactions []Reaction) []ReactionCount {
+	counts := make([]ReactionCount, 0)
+	index := make(map[string]int)
+	for _, reaction := range reactions {
+		i, ok := index[reaction.Emoji]
+		if !ok {
+			i = len(counts)
+			index[reaction.Emoji] = i
+			counts = append(counts, ReactionCount{Emoji: reaction.Emoji})
+		}
+		counts[i].Count++
+		counts[i].Users = append(counts[i].Users, reaction.UserID)
+	}
+	return counts
 }
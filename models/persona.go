@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Persona represents a reusable assistant personality that sessions can use.
+// Personas without an owner are shared with every user and managed by admins.
+type Persona struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	UserID       *string   `json:"userId,omitempty"` // owner, nil for shared personas
+	Name         string    `json:"name"`
+	SystemPrompt string    `json:"systemPrompt"`
+	Avatar       string    `json:"avatar,omitempty"` // image URL or emoji
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+
+	// Default generation settings for sessions using the persona
+	Provider      string     `json:"provider,omitempty"`
+	Model         string     `json:"model,omitempty"`
+	Temperature   *float64   `json:"temperature,omitempty"`
+	TopP          *float64   `json:"topP,omitempty"`
+	MaxTokens     int        `json:"maxTokens,omitempty"`
+	StopSequences StringList `json:"stopSequences,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// UserQuota holds a user's plan, limit overrides and usage in the current
+// quota period. Users without a row are on the default plan with no usage.
+type UserQuota struct {
+	UserID      string    `json:"userId" gorm:"primaryKey"`
+	Plan        string    `json:"plan,omitempty"`       // empty for the default plan
+	TokenLimit  *int64    `json:"tokenLimit,omitempty"` // overrides the plan's limit, 0 is unlimited
+	CostLimit   *float64  `json:"costLimit,omitempty"`  // overrides the plan's limit, 0 is unlimited
+	PeriodStart time.Time `json:"periodStart"`
+	TokensUsed  int64     `json:"tokensUsed"`
+	CostUsed    float64   `json:"costUsed"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// TableName returns the table name of user quotas
+func (UserQuota) TableName() string {
+	return "user_quotas"
+}
@@ -7,9 +7,31 @@ import (
 // Session represents a chat session
 type Session struct {
 	ID         string    `json:"id" gorm:"primaryKey"`
+	UserID     string    `json:"userId"` // owner of the session
 	Title      string    `json:"title"`
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 	IsFavorite bool      `json:"isFavorite"`
+	Provider   string    `json:"provider,omitempty"` // AI provider name, empty for the default
 	Messages   []Message `json:"messages" gorm:"foreignKey:SessionID"`
+
+	// Last message of the active branch; its path from the first message is
+	// the conversation shown and sent to the AI
+	ActiveMessageID *string `json:"activeMessageId,omitempty"`
+
+	// Persona used by the session and an optional system prompt override
+	PersonaID    *string  `json:"personaId,omitempty"`
+	Persona      *Persona `json:"persona,omitempty" gorm:"foreignKey:PersonaID"`
+	SystemPrompt string   `json:"systemPrompt,omitempty"`
+
+	// Generation settings; zero values use the provider's defaults
+	Model         string     `json:"model,omitempty"`
+	Temperature   *float64   `json:"temperature,omitempty"`
+	TopP          *float64   `json:"topP,omitempty"`
+	MaxTokens     int        `json:"maxTokens,omitempty"`
+	StopSequences StringList `json:"stopSequences,omitempty"`
+
+	// Rolling summary of the turns that no longer fit in the AI context window
+	Summary         string     `json:"summary,omitempty"`
+	SummarizedUntil *time.Time `json:"summarizedUntil,omitempty"`
 }
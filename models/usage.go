@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// UsageRecord holds the tokens and cost of one AI response, including the
+// requests that prepared its prompt. Records outlive their session, so the
+// usage reported to a user matches what their quota counted.
+type UsageRecord struct {
+	ID               string    `json:"id" gorm:"primaryKey"`
+	UserID           string    `json:"userId"`
+	SessionID        string    `json:"sessionId"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	OverheadTokens   int       `json:"overheadTokens"` // summarizing older turns
+	Cost             float64   `json:"cost"`           // USD, including the overhead
+	CreatedAt        time.Time `json:"createdAt"`      // UTC
+}
+
+// TableName returns the table name of usage records
+func (UsageRecord) TableName() string {
+	return "usage_records"
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// User represents a registered user
+type User struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	Email        string    `json:"email"`
+	Name         string    `json:"name,omitempty"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"` // "user" | "admin"
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// RefreshToken represents an issued refresh token. Only its hash is stored;
+// a token is revoked when it is rotated or the user logs out.
+type RefreshToken struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     string     `json:"userId"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	ReplacedBy string     `json:"replacedBy,omitempty"` // the token issued when this one was rotated
+}
+
+// Roles a user can have
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
@@ -0,0 +1,414 @@
+package repository
+
+import (
+	"chatbot_backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm creates repositories backed by the database
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Sessions:  &gormSessions{db: db},
+		Messages:  &gormMessages{db: db},
+		Reactions: &gormReactions{db: db},
+		Personas:  &gormPersonas{db: db},
+		Search:    &gormSearch{db: db},
+		transaction: func(ctx context.Context, fn func(Repositories) error) error {
+			return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return fn(NewGorm(tx))
+			})
+		},
+	}
+}
+
+// gormSessions stores sessions in the database
+type gormSessions struct {
+	db *gorm.DB
+}
+
+// ListByUser returns a page of the user's sessions, most recently updated first
+func (r *gormSessions) ListByUser(ctx context.Context, userID string, page Page) ([]models.Session, error) {
+	db := r.db.WithContext(ctx).Where("user_id = ?", userID).Limit(page.Limit)
+	switch {
+	case page.After != nil:
+		db = db.Where("updated_at > ? OR (updated_at = ? AND id > ?)", page.After.Time, page.After.Time, page.After.ID).
+			Order("updated_at ASC").Order("id ASC")
+	case page.Before != nil:
+		db = db.Where("updated_at < ? OR (updated_at = ? AND id < ?)", page.Before.Time, page.Before.Time, page.Before.ID).
+			Order("updated_at DESC").Order("id DESC")
+	default:
+		db = db.Order("updated_at DESC").Order("id DESC")
+	}
+
+	var sessions []models.Session
+	if err := db.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	if page.After != nil {
+		// Taken oldest first to stay next to the cursor
+		for i, j := 0, len(sessions)-1; i < j; i, j = i+1, j-1 {
+			sessions[i], sessions[j] = sessions[j], sessions[i]
+		}
+	}
+	return sessions, nil
+}
+
+// Get returns the user's session with the ID
+func (r *gormSessions) Get(ctx context.Context, userID, id string) (models.Session, error) {
+	var session models.Session
+	err := r.db.WithContext(ctx).First(&session, "id = ? AND user_id = ?", id, userID).Error
+	return session, notFound(err)
+}
+
+// Create inserts a session
+func (r *gormSessions) Create(ctx context.Context, session *models.Session) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(session).Error
+}
+
+// Save updates every field of the session
+func (r *gormSessions) Save(ctx context.Context, session *models.Session) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(session).Error
+}
+
+// SaveSummary updates the session's rolling summary
+func (r *gormSessions) SaveSummary(ctx context.Context, session *models.Session) error {
+	return r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"summary":          session.Summary,
+		"summarized_until": session.SummarizedUntil,
+	}).Error
+}
+
+// Touch sets the time the session was last updated
+func (r *gormSessions) Touch(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).Update("updated_at", at).Error
+}
+
+// SetActiveMessage sets the last message of the session's active branch
+func (r *gormSessions) SetActiveMessage(ctx context.Context, id, messageID string) error {
+	return r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).Update("active_message_id", messageID).Error
+}
+
+// Delete deletes the session and its messages
+func (r *gormSessions) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", id).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.Session{}).Error
+	})
+}
+
+// gormMessages stores messages in the database
+type gormMessages struct {
+	db *gorm.DB
+}
+
+// List returns the session's messages in chronological order, without their reactions
+func (r *gormMessages) List(ctx context.Context, sessionID string) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("timestamp ASC").Order("id ASC").Find(&messages).Error
+	return messages, err
+}
+
+// branchIDs selects the IDs of the messages from the first message of a
+// session to a leaf, following the parent IDs. UNION stops at a cycle.
+const branchIDs = `WITH RECURSIVE branch(id, parent_id) AS (
+    SELECT id, parent_id FROM messages WHERE id = ? AND session_id = ?
+    UNION
+    SELECT m.id, m.parent_id FROM messages m JOIN branch b ON m.id = b.parent_id
+)
+SELECT id FROM branch`
+
+// ListPage returns a page of the session's messages, or of the branch ending
+// at the leaf, in chronological order
+func (r *gormMessages) ListPage(ctx context.Context, sessionID, leafID string, page Page) ([]models.Message, error) {
+	db := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Limit(page.Limit)
+	if leafID != "" {
+		db = db.Where("id IN ("+branchIDs+")", leafID, sessionID)
+	}
+	switch {
+	case page.After != nil:
+		db = db.Where("timestamp > ? OR (timestamp = ? AND id > ?)", page.After.Time, page.After.Time, page.After.ID).
+			Order("timestamp ASC").Order("id ASC")
+	case page.Before != nil:
+		db = db.Where("timestamp < ? OR (timestamp = ? AND id < ?)", page.Before.Time, page.Before.Time, page.Before.ID).
+			Order("timestamp DESC").Order("id DESC")
+	default:
+		db = db.Order("timestamp DESC").Order("id DESC")
+	}
+
+	var messages []models.Message
+	if err := db.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if page.After == nil {
+		// Taken newest first to stay next to the cursor
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+// ListReplies returns the session's messages replying to the parents in
+// chronological order
+func (r *gormMessages) ListReplies(ctx context.Context, sessionID string, parentIDs []string) ([]models.Message, error) {
+	var ids []string
+	roots := false
+	for _, id := range parentIDs {
+		if id == "" {
+			roots = true
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	parents := r.db.Where("parent_id IN ?", ids)
+	if roots {
+		parents = parents.Or("parent_id IS NULL")
+	}
+
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Where(parents).
+		Order("timestamp ASC").Order("id ASC").Find(&messages).Error
+	return messages, err
+}
+
+// Get returns the session's message with the ID
+func (r *gormMessages) Get(ctx context.Context, sessionID, id string) (models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).First(&message, "id = ? AND session_id = ?", id, sessionID).Error
+	return message, notFound(err)
+}
+
+// Find returns the message with the ID in any session
+func (r *gormMessages) Find(ctx context.Context, id string) (models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).First(&message, "id = ?", id).Error
+	return message, notFound(err)
+}
+
+// Create inserts a message
+func (r *gormMessages) Create(ctx context.Context, message *models.Message) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(message).Error
+}
+
+// Save updates every field of the message
+func (r *gormMessages) Save(ctx context.Context, message *models.Message) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(message).Error
+}
+
+// gormReactions stores reactions in the database
+type gormReactions struct {
+	db *gorm.DB
+}
+
+// Add saves the reaction unless the user already reacted to the message with
+// the same emoji. The unique (message, user, emoji) constraint settles
+// concurrent requests.
+func (r *gormReactions) Add(ctx context.Context, reaction *models.Reaction) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(reaction).Error
+}
+
+// Remove deletes the user's reaction to the message with the emoji, if any
+func (r *gormReactions) Remove(ctx context.Context, messageID, userID, emoji string) error {
+	return r.db.WithContext(ctx).Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&models.Reaction{}).Error
+}
+
+// ListByMessage returns the message's reactions, oldest first
+func (r *gormReactions) ListByMessage(ctx context.Context, messageID string) ([]models.Reaction, error) {
+	var reactions []models.Reaction
+	err := orderReactions(r.db.WithContext(ctx)).Where("message_id = ?", messageID).Find(&reactions).Error
+	return reactions, err
+}
+
+// ListByMessages returns the reactions to the messages, oldest first
+func (r *gormReactions) ListByMessages(ctx context.Context, messageIDs []string) ([]models.Reaction, error) {
+	var reactions []models.Reaction
+	err := orderReactions(r.db.WithContext(ctx)).Where("message_id IN ?", messageIDs).Find(&reactions).Error
+	return reactions, err
+}
+
+// orderReactions sorts reactions oldest first
+func orderReactions(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at ASC").Order("id ASC")
+}
+
+// gormPersonas looks up personas in the database
+type gormPersonas struct {
+	db *gorm.DB
+}
+
+// Get returns the persona with the ID
+func (r *gormPersonas) Get(ctx context.Context, id string) (models.Persona, error) {
+	var persona models.Persona
+	err := r.db.WithContext(ctx).First(&persona, "id = ?", id).Error
+	return persona, notFound(err)
+}
+
+// gormSearch searches the database. PostgreSQL uses the full-text indexes,
+// in Turkish and English; other databases match every word of the query
+// with LIKE.
+type gormSearch struct {
+	db *gorm.DB
+}
+
+// fullTextSearch finds messages and session titles matching the query in
+// either language. Snippets are highlighted in the language that matched,
+// after the highlight markers are removed from the text.
+const fullTextSearch = `WITH q AS (
+    SELECT websearch_to_tsquery('turkish', @text) AS turkish, websearch_to_tsquery('english', @text) AS english
+)
+SELECT m.session_id, s.title AS session_title, m.id AS message_id, m.sender,
+    CASE WHEN to_tsvector('turkish', m.content) @@ q.turkish
+        THEN ts_headline('turkish', translate(m.content, chr(2) || chr(3), ''), q.turkish, @options)
+        ELSE ts_headline('english', translate(m.content, chr(2) || chr(3), ''), q.english, @options)
+    END AS snippet,
+    ts_rank(m.search_vector, q.turkish || q.english) AS rank,
+    m.timestamp AS "timestamp"
+FROM q, messages m JOIN sessions s ON s.id = m.session_id
+WHERE s.user_id = @user AND m.status = 'completed' AND m.search_vector @@ (q.turkish || q.english)
+    %s`
+
+// fullTextTitleSearch is the part of fullTextSearch matching session titles
+const fullTextTitleSearch = `
+UNION ALL
+SELECT s.id, s.title, '', '',
+    CASE WHEN to_tsvector('turkish', s.title) @@ q.turkish
+        THEN ts_headline('turkish', translate(s.title, chr(2) || chr(3), ''), q.turkish, @options)
+        ELSE ts_headline('english', translate(s.title, chr(2) || chr(3), ''), q.english, @options)
+    END,
+    ts_rank(s.search_vector, q.turkish || q.english),
+    s.updated_at
+FROM q, sessions s
+WHERE s.user_id = @user AND s.search_vector @@ (q.turkish || q.english)
+    %s`
+
+// headlineOptions selects the snippets ts_headline returns
+const headlineOptions = "StartSel=" + HighlightStart + ", StopSel=" + HighlightEnd + ", MaxWords=30, MinWords=10, MaxFragments=2"
+
+// Search returns the best hits of the query
+func (r *gormSearch) Search(ctx context.Context, userID string, query SearchQuery) ([]SearchHit, error) {
+	db := r.db.WithContext(ctx)
+	if db.Dialector.Name() != "postgres" {
+		return r.match(db, userID, query)
+	}
+
+	args := map[string]interface{}{
+		"text":    query.Text,
+		"user":    userID,
+		"options": headlineOptions,
+		"limit":   query.Limit,
+	}
+	var messageFilters, titleFilters []string
+	if query.Sender != "" {
+		messageFilters = append(messageFilters, "AND m.sender = @sender")
+		args["sender"] = query.Sender
+	}
+	if query.From != nil {
+		messageFilters = append(messageFilters, "AND m.timestamp >= @from")
+		titleFilters = append(titleFilters, "AND s.updated_at >= @from")
+		args["from"] = *query.From
+	}
+	if query.To != nil {
+		messageFilters = append(messageFilters, "AND m.timestamp < @to")
+		titleFilters = append(titleFilters, "AND s.updated_at < @to")
+		args["to"] = *query.To
+	}
+	if query.FavoritesOnly {
+		messageFilters = append(messageFilters, "AND s.is_favorite")
+		titleFilters = append(titleFilters, "AND s.is_favorite")
+	}
+
+	sql := fmt.Sprintf(fullTextSearch, strings.Join(messageFilters, " "))
+	if query.Sender == "" {
+		sql += fmt.Sprintf(fullTextTitleSearch, strings.Join(titleFilters, " "))
+	}
+	sql += "\nORDER BY rank DESC, \"timestamp\" DESC\nLIMIT @limit"
+
+	var hits []SearchHit
+	err := db.Raw(sql, args).Scan(&hits).Error
+	return hits, err
+}
+
+// match finds the messages and session titles containing every word of the
+// query, ranked by how often the words occur
+func (r *gormSearch) match(db *gorm.DB, userID string, query SearchQuery) ([]SearchHit, error) {
+	terms := searchTerms(query.Text)
+	like := likeOperator(db)
+
+	messages := db.Table("messages").
+		Select("messages.session_id, sessions.title AS session_title, messages.id AS message_id, messages.sender, "+
+			"messages.content AS snippet, messages.timestamp").
+		Joins("JOIN sessions ON sessions.id = messages.session_id").
+		Where("sessions.user_id = ? AND messages.status = ?", userID, models.MessageStatusCompleted)
+	titles := db.Table("sessions").
+		Select("sessions.id AS session_id, sessions.title AS session_title, sessions.title AS snippet, sessions.updated_at AS timestamp").
+		Where("sessions.user_id = ?", userID)
+	for _, term := range terms {
+		messages = messages.Where("messages.content "+like+" ?", "%"+term+"%")
+		titles = titles.Where("sessions.title "+like+" ?", "%"+term+"%")
+	}
+	if query.Sender != "" {
+		messages = messages.Where("messages.sender = ?", query.Sender)
+	}
+	if query.From != nil {
+		messages = messages.Where("messages.timestamp >= ?", *query.From)
+		titles = titles.Where("sessions.updated_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		messages = messages.Where("messages.timestamp < ?", *query.To)
+		titles = titles.Where("sessions.updated_at < ?", *query.To)
+	}
+	if query.FavoritesOnly {
+		messages = messages.Where("sessions.is_favorite = ?", true)
+		titles = titles.Where("sessions.is_favorite = ?", true)
+	}
+
+	var hits []SearchHit
+	if err := messages.Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	if query.Sender == "" {
+		var titleHits []SearchHit
+		if err := titles.Scan(&titleHits).Error; err != nil {
+			return nil, err
+		}
+		hits = append(hits, titleHits...)
+	}
+
+	// LIKE ignores case for ASCII only; the count drops anything else
+	matched := hits[:0]
+	for _, hit := range hits {
+		if rank := matchTerms(hit.Snippet, terms); rank > 0 {
+			hit.Rank = float64(rank)
+			hit.Snippet = highlight(hit.Snippet, terms)
+			matched = append(matched, hit)
+		}
+	}
+	return rankHits(matched, query.Limit), nil
+}
+
+// notFound translates gorm's missing record error to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// likeOperator returns the case-insensitive LIKE operator of the database's
+// dialect. SQLite has no ILIKE, but its LIKE ignores case already.
+func likeOperator(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"chatbot_backend/migrations"
+	"chatbot_backend/models"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestGorm returns repositories on a migrated SQLite database
+func newTestGorm(t *testing.T) Repositories {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return NewGorm(db)
+}
+
+// ids returns the IDs of the messages
+func ids(messages []models.Message) string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestMessagePages(t *testing.T) {
+	for name, repos := range map[string]Repositories{
+		"gorm":   newTestGorm(t),
+		"memory": NewMemoryStore().Repositories(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			start := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+			for _, session := range []models.Session{{ID: "session-1", UserID: "alice"}, {ID: "session-2", UserID: "alice"}} {
+				if err := repos.Sessions.Create(ctx, &session); err != nil {
+					t.Fatalf("create session: %v", err)
+				}
+			}
+
+			// m2b is a regenerated version of m2, which the branch to m4 goes through
+			for i, message := range []struct{ id, parentID, sessionID string }{
+				{"m1", "", "session-1"},
+				{"m2", "m1", "session-1"},
+				{"m3", "m2", "session-1"},
+				{"m4", "m3", "session-1"},
+				{"m2b", "m1", "session-1"},
+				{"other", "", "session-2"},
+			} {
+				created := models.Message{
+					ID:        message.id,
+					SessionID: message.sessionID,
+					Sender:    "user",
+					Timestamp: start.Add(time.Duration(i) * time.Minute),
+					Status:    models.MessageStatusCompleted,
+				}
+				if parentID := message.parentID; parentID != "" {
+					created.ParentID = &parentID
+				}
+				if err := repos.Messages.Create(ctx, &created); err != nil {
+					t.Fatalf("create message: %v", err)
+				}
+			}
+			cursor := func(minutes int, id string) *Cursor {
+				return &Cursor{Time: start.Add(time.Duration(minutes) * time.Minute), ID: id}
+			}
+
+			tests := []struct {
+				name   string
+				leafID string
+				page   Page
+				want   string
+			}{
+				{name: "newest of the branch", leafID: "m4", page: Page{Limit: 2}, want: "[m3 m4]"},
+				{name: "branch before a cursor", leafID: "m4", page: Page{Limit: 5, Before: cursor(2, "m3")}, want: "[m1 m2]"},
+				{name: "branch after a cursor", leafID: "m4", page: Page{Limit: 2, After: cursor(0, "m1")}, want: "[m2 m3]"},
+				{name: "other branch", leafID: "m2b", page: Page{Limit: 5}, want: "[m1 m2b]"},
+				{name: "every branch", page: Page{Limit: 10}, want: "[m1 m2 m3 m4 m2b]"},
+				{name: "newest of every branch", page: Page{Limit: 1}, want: "[m2b]"},
+				{name: "leaf of another session", leafID: "other", page: Page{Limit: 5}, want: "[]"},
+			}
+			for _, tt := range tests {
+				messages, err := repos.Messages.ListPage(ctx, "session-1", tt.leafID, tt.page)
+				if err != nil {
+					t.Fatalf("%s: ListPage: %v", tt.name, err)
+				}
+				if got := ids(messages); got != tt.want {
+					t.Errorf("%s: ListPage = %s, want %s", tt.name, got, tt.want)
+				}
+			}
+
+			replies, err := repos.Messages.ListReplies(ctx, "session-1", []string{"m1", ""})
+			if err != nil {
+				t.Fatalf("ListReplies: %v", err)
+			}
+			if got := ids(replies); got != "[m1 m2 m2b]" {
+				t.Errorf("ListReplies = %s, want the first message and both versions of its reply", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+package repository
+
+import (
+	"chatbot_backend/models"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions, messages, reactions and personas in memory. It is meant
+// for tests and demos; nothing survives a restart. Transactions run one at a
+// time and a failed one restores the store as it was when it started, which
+// also undoes writes made outside it in the meantime.
+type MemoryStore struct {
+	txMu      sync.Mutex
+	mu        sync.RWMutex
+	sessions  map[string]models.Session
+	messages  map[string]models.Message
+	reactions map[string]models.Reaction
+	personas  map[string]models.Persona
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  make(map[string]models.Session),
+		messages:  make(map[string]models.Message),
+		reactions: make(map[string]models.Reaction),
+		personas:  make(map[string]models.Persona),
+	}
+}
+
+// Repositories returns the repositories backed by the store
+func (s *MemoryStore) Repositories() Repositories {
+	return Repositories{
+		Sessions:    &memorySessions{store: s},
+		Messages:    &memoryMessages{store: s},
+		Reactions:   &memoryReactions{store: s},
+		Personas:    &memoryPersonas{store: s},
+		Search:      &memorySearch{store: s},
+		transaction: s.transaction,
+	}
+}
+
+// AddPersona adds or replaces a persona
+func (s *MemoryStore) AddPersona(persona models.Persona) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.personas[persona.ID] = persona
+}
+
+// transaction runs fn and restores the store if it fails
+func (s *MemoryStore) transaction(ctx context.Context, fn func(Repositories) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	sessions, messages, reactions, personas := copyMap(s.sessions), copyMap(s.messages), copyMap(s.reactions), copyMap(s.personas)
+	s.mu.RUnlock()
+
+	// Nested transactions join this one
+	repos := s.Repositories()
+	repos.transaction = nil
+	if err := fn(repos); err != nil {
+		s.mu.Lock()
+		s.sessions, s.messages, s.reactions, s.personas = sessions, messages, reactions, personas
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// copyMap returns a shallow copy of a map
+func copyMap[V any](m map[string]V) map[string]V {
+	copied := make(map[string]V, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+// memorySessions stores sessions in a MemoryStore
+type memorySessions struct {
+	store *MemoryStore
+}
+
+// ListByUser returns a page of the user's sessions, most recently updated first
+func (r *memorySessions) ListByUser(ctx context.Context, userID string, page Page) ([]models.Session, error) {
+	sessions := r.filter(func(session models.Session) bool {
+		if session.UserID != userID {
+			return false
+		}
+		at := Cursor{Time: session.UpdatedAt, ID: session.ID}
+		if page.After != nil && !page.After.Before(at) {
+			return false
+		}
+		return page.Before == nil || at.Before(*page.Before)
+	})
+
+	if len(sessions) > page.Limit {
+		if page.After != nil {
+			// The oldest of the newer sessions are next to the cursor
+			return sessions[len(sessions)-page.Limit:], nil
+		}
+		return sessions[:page.Limit], nil
+	}
+	return sessions, nil
+}
+
+// Get returns the user's session with the ID
+func (r *memorySessions) Get(ctx context.Context, userID, id string) (models.Session, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	session, ok := r.store.sessions[id]
+	if !ok || session.UserID != userID {
+		return models.Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+// Create inserts a session
+func (r *memorySessions) Create(ctx context.Context, session *models.Session) error {
+	return r.Save(ctx, session)
+}
+
+// Save updates every field of the session
+func (r *memorySessions) Save(ctx context.Context, session *models.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored := *session
+	stored.Persona = nil
+	stored.Messages = nil
+	r.store.sessions[session.ID] = stored
+	return nil
+}
+
+// SaveSummary updates the session's rolling summary
+func (r *memorySessions) SaveSummary(ctx context.Context, session *models.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stored, ok := r.store.sessions[session.ID]; ok {
+		stored.Summary = session.Summary
+		stored.SummarizedUntil = session.SummarizedUntil
+		r.store.sessions[session.ID] = stored
+	}
+	return nil
+}
+
+// Touch sets the time the session was last updated
+func (r *memorySessions) Touch(ctx context.Context, id string, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stored, ok := r.store.sessions[id]; ok {
+		stored.UpdatedAt = at
+		r.store.sessions[id] = stored
+	}
+	return nil
+}
+
+// SetActiveMessage sets the last message of the session's active branch
+func (r *memorySessions) SetActiveMessage(ctx context.Context, id, messageID string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stored, ok := r.store.sessions[id]; ok {
+		stored.ActiveMessageID = &messageID
+		r.store.sessions[id] = stored
+	}
+	return nil
+}
+
+// Delete deletes the session and its messages
+func (r *memorySessions) Delete(ctx context.Context, id string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for messageID, message := range r.store.messages {
+		if message.SessionID == id {
+			delete(r.store.messages, messageID)
+		}
+	}
+	for reactionID, reaction := range r.store.reactions {
+		if _, ok := r.store.messages[reaction.MessageID]; !ok {
+			delete(r.store.reactions, reactionID)
+		}
+	}
+	delete(r.store.sessions, id)
+	return nil
+}
+
+// filter returns the sessions matching the predicate, most recently updated first
+func (r *memorySessions) filter(match func(models.Session) bool) []models.Session {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var sessions []models.Session
+	for _, session := range r.store.sessions {
+		if match(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return (Cursor{Time: sessions[j].UpdatedAt, ID: sessions[j].ID}).Before(Cursor{Time: sessions[i].UpdatedAt, ID: sessions[i].ID})
+	})
+	return sessions
+}
+
+// memoryMessages stores messages in a MemoryStore
+type memoryMessages struct {
+	store *MemoryStore
+}
+
+// List returns the session's messages in chronological order, without their reactions
+func (r *memoryMessages) List(ctx context.Context, sessionID string) ([]models.Message, error) {
+	return r.filter(func(message models.Message) bool {
+		return message.SessionID == sessionID
+	}), nil
+}
+
+// ListPage returns a page of the session's messages, or of the branch ending
+// at the leaf, in chronological order
+func (r *memoryMessages) ListPage(ctx context.Context, sessionID, leafID string, page Page) ([]models.Message, error) {
+	branch := r.branch(sessionID, leafID)
+	messages := r.filter(func(message models.Message) bool {
+		if message.SessionID != sessionID || (leafID != "" && !branch[message.ID]) {
+			return false
+		}
+		at := Cursor{Time: message.Timestamp, ID: message.ID}
+		if page.After != nil && !page.After.Before(at) {
+			return false
+		}
+		return page.Before == nil || at.Before(*page.Before)
+	})
+
+	if len(messages) > page.Limit {
+		if page.After != nil {
+			// The oldest of the newer messages are next to the cursor
+			return messages[:page.Limit], nil
+		}
+		return messages[len(messages)-page.Limit:], nil
+	}
+	return messages, nil
+}
+
+// branch returns the IDs of the session's messages from the first message to
+// the leaf
+func (r *memoryMessages) branch(sessionID, leafID string) map[string]bool {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	branch := make(map[string]bool)
+	for id := leafID; id != "" && !branch[id]; {
+		message, ok := r.store.messages[id]
+		if !ok || message.SessionID != sessionID {
+			break
+		}
+		branch[id] = true
+		id = ""
+		if message.ParentID != nil {
+			id = *message.ParentID
+		}
+	}
+	return branch
+}
+
+// ListReplies returns the session's messages replying to the parents in
+// chronological order
+func (r *memoryMessages) ListReplies(ctx context.Context, sessionID string, parentIDs []string) ([]models.Message, error) {
+	parents := make(map[string]bool, len(parentIDs))
+	for _, id := range parentIDs {
+		parents[id] = true
+	}
+	return r.filter(func(message models.Message) bool {
+		parentID := ""
+		if message.ParentID != nil {
+			parentID = *message.ParentID
+		}
+		return message.SessionID == sessionID && parents[parentID]
+	}), nil
+}
+
+// Get returns the session's message with the ID
+func (r *memoryMessages) Get(ctx context.Context, sessionID, id string) (models.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	message, ok := r.store.messages[id]
+	if !ok || message.SessionID != sessionID {
+		return models.Message{}, ErrNotFound
+	}
+	return message, nil
+}
+
+// Find returns the message with the ID in any session
+func (r *memoryMessages) Find(ctx context.Context, id string) (models.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	message, ok := r.store.messages[id]
+	if !ok {
+		return models.Message{}, ErrNotFound
+	}
+	return message, nil
+}
+
+// Create inserts a message
+func (r *memoryMessages) Create(ctx context.Context, message *models.Message) error {
+	return r.Save(ctx, message)
+}
+
+// Save updates every field of the message
+func (r *memoryMessages) Save(ctx context.Context, message *models.Message) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored := *message
+	stored.Reactions = nil
+	r.store.messages[message.ID] = stored
+	return nil
+}
+
+// filter returns the messages matching the predicate in chronological order
+func (r *memoryMessages) filter(match func(models.Message) bool) []models.Message {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var messages []models.Message
+	for _, message := range r.store.messages {
+		if match(message) {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].ID < messages[j].ID
+		}
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+	return messages
+}
+
+// memoryReactions stores reactions in a MemoryStore
+type memoryReactions struct {
+	store *MemoryStore
+}
+
+// Add saves the reaction unless the user already reacted to the message with
+// the same emoji
+func (r *memoryReactions) Add(ctx context.Context, reaction *models.Reaction) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.store.reactions {
+		if stored.MessageID == reaction.MessageID && stored.UserID == reaction.UserID && stored.Emoji == reaction.Emoji {
+			return nil
+		}
+	}
+	r.store.reactions[reaction.ID] = *reaction
+	return nil
+}
+
+// Remove deletes the user's reaction to the message with the emoji, if any
+func (r *memoryReactions) Remove(ctx context.Context, messageID, userID, emoji string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for id, stored := range r.store.reactions {
+		if stored.MessageID == messageID && stored.UserID == userID && stored.Emoji == emoji {
+			delete(r.store.reactions, id)
+		}
+	}
+	return nil
+}
+
+// ListByMessage returns the message's reactions, oldest first
+func (r *memoryReactions) ListByMessage(ctx context.Context, messageID string) ([]models.Reaction, error) {
+	return r.filter(func(reaction models.Reaction) bool {
+		return reaction.MessageID == messageID
+	}), nil
+}
+
+// ListByMessages returns the reactions to the messages, oldest first
+func (r *memoryReactions) ListByMessages(ctx context.Context, messageIDs []string) ([]models.Reaction, error) {
+	ids := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		ids[id] = true
+	}
+	return r.filter(func(reaction models.Reaction) bool {
+		return ids[reaction.MessageID]
+	}), nil
+}
+
+// filter returns the reactions matching the predicate, oldest first
+func (r *memoryReactions) filter(match func(models.Reaction) bool) []models.Reaction {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var reactions []models.Reaction
+	for _, reaction := range r.store.reactions {
+		if match(reaction) {
+			reactions = append(reactions, reaction)
+		}
+	}
+	sort.Slice(reactions, func(i, j int) bool {
+		if reactions[i].CreatedAt.Equal(reactions[j].CreatedAt) {
+			return reactions[i].ID < reactions[j].ID
+		}
+		return reactions[i].CreatedAt.Before(reactions[j].CreatedAt)
+	})
+	return reactions
+}
+
+// memoryPersonas looks up personas in a MemoryStore
+type memoryPersonas struct {
+	store *MemoryStore
+}
+
+// Get returns the persona with the ID
+func (r *memoryPersonas) Get(ctx context.Context, id string) (models.Persona, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	persona, ok := r.store.personas[id]
+	if !ok {
+		return models.Persona{}, ErrNotFound
+	}
+	return persona, nil
+}
+
+// memorySearch searches a MemoryStore, matching every word of the query
+// without full-text support
+type memorySearch struct {
+	store *MemoryStore
+}
+
+// Search returns the best hits of the query
+func (r *memorySearch) Search(ctx context.Context, userID string, query SearchQuery) ([]SearchHit, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	terms := searchTerms(query.Text)
+	var hits []SearchHit
+	for _, session := range r.store.sessions {
+		if session.UserID != userID || (query.FavoritesOnly && !session.IsFavorite) {
+			continue
+		}
+		if query.Sender == "" && inRange(session.UpdatedAt, query) {
+			if rank := matchTerms(session.Title, terms); rank > 0 {
+				hits = append(hits, SearchHit{
+					SessionID:    session.ID,
+					SessionTitle: session.Title,
+					Snippet:      highlight(session.Title, terms),
+					Rank:         float64(rank),
+					Timestamp:    session.UpdatedAt,
+				})
+			}
+		}
+	}
+	for _, message := range r.store.messages {
+		session, ok := r.store.sessions[message.SessionID]
+		if !ok || session.UserID != userID || (query.FavoritesOnly && !session.IsFavorite) {
+			continue
+		}
+		if message.Status != models.MessageStatusCompleted || (query.Sender != "" && message.Sender != query.Sender) || !inRange(message.Timestamp, query) {
+			continue
+		}
+		if rank := matchTerms(message.Content, terms); rank > 0 {
+			hits = append(hits, SearchHit{
+				SessionID:    session.ID,
+				SessionTitle: session.Title,
+				MessageID:    message.ID,
+				Sender:       message.Sender,
+				Snippet:      highlight(message.Content, terms),
+				Rank:         float64(rank),
+				Timestamp:    message.Timestamp,
+			})
+		}
+	}
+	return rankHits(hits, query.Limit), nil
+}
+
+// inRange reports whether the time is within the query's date range
+func inRange(at time.Time, query SearchQuery) bool {
+	return (query.From == nil || !at.Before(*query.From)) && (query.To == nil || at.Before(*query.To))
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"time"
+)
+
+// Cursor is a position in a list ordered by time, with ties broken by ID
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// Page selects at most Limit records of a list, starting at the newest.
+// With Before set it starts at the newest record older than the cursor; with
+// After set it takes the oldest records newer than the cursor instead.
+type Page struct {
+	Limit  int
+	Before *Cursor
+	After  *Cursor
+}
+
+// Before reports whether the cursor comes before the other one
+func (c Cursor) Before(other Cursor) bool {
+	if c.Time.Equal(other.Time) {
+		return c.ID < other.ID
+	}
+	return c.Time.Before(other.Time)
+}
@@ -0,0 +1,102 @@
+// Package repository stores and searches sessions, messages, reactions and personas. The gorm
+// implementation backs the server; the in-memory implementation lets the
+// chat service and its handlers run without a database.
+package repository
+
+import (
+	"chatbot_backend/models"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a record does not exist, or belongs to
+// another user
+var ErrNotFound = errors.New("record not found")
+
+// SessionRepository stores chat sessions
+type SessionRepository interface {
+	// ListByUser returns a page of the user's sessions ordered by the time
+	// they were last updated, most recent first
+	ListByUser(ctx context.Context, userID string, page Page) ([]models.Session, error)
+	// Get returns the user's session with the ID
+	Get(ctx context.Context, userID, id string) (models.Session, error)
+	Create(ctx context.Context, session *models.Session) error
+	// Save updates every field of the session but not its associations
+	Save(ctx context.Context, session *models.Session) error
+	// SaveSummary updates the session's rolling summary only
+	SaveSummary(ctx context.Context, session *models.Session) error
+	// Touch sets the time the session was last updated
+	Touch(ctx context.Context, id string, at time.Time) error
+	// SetActiveMessage sets the last message of the session's active branch
+	SetActiveMessage(ctx context.Context, id, messageID string) error
+	// Delete deletes the session and its messages
+	Delete(ctx context.Context, id string) error
+}
+
+// MessageRepository stores the messages of sessions
+type MessageRepository interface {
+	// List returns the session's messages in chronological order, without
+	// their reactions
+	List(ctx context.Context, sessionID string) ([]models.Message, error)
+	// ListPage returns a page of the session's messages in chronological
+	// order, without their reactions. With a leaf ID only the branch from the
+	// first message to the leaf is listed.
+	ListPage(ctx context.Context, sessionID, leafID string, page Page) ([]models.Message, error)
+	// ListReplies returns the session's messages replying to the parents in
+	// chronological order; an empty parent ID stands for the first messages
+	ListReplies(ctx context.Context, sessionID string, parentIDs []string) ([]models.Message, error)
+	// Get returns the session's message with the ID
+	Get(ctx context.Context, sessionID, id string) (models.Message, error)
+	// Find returns the message with the ID in any session
+	Find(ctx context.Context, id string) (models.Message, error)
+	Create(ctx context.Context, message *models.Message) error
+	// Save updates every field of the message but not its reactions
+	Save(ctx context.Context, message *models.Message) error
+}
+
+// ReactionRepository stores users' reactions to messages
+type ReactionRepository interface {
+	// Add saves the reaction unless the user already reacted to the
+	// message with the same emoji
+	Add(ctx context.Context, reaction *models.Reaction) error
+	// Remove deletes the user's reaction to the message with the emoji, if any
+	Remove(ctx context.Context, messageID, userID, emoji string) error
+	// ListByMessage returns the message's reactions, oldest first
+	ListByMessage(ctx context.Context, messageID string) ([]models.Reaction, error)
+	// ListByMessages returns the reactions to the messages, oldest first
+	ListByMessages(ctx context.Context, messageIDs []string) ([]models.Reaction, error)
+}
+
+// SearchRepository searches the messages and session titles of a user's
+// sessions
+type SearchRepository interface {
+	// Search returns the best hits of the query, at most query.Limit of them
+	Search(ctx context.Context, userID string, query SearchQuery) ([]SearchHit, error)
+}
+
+// PersonaRepository looks up personas
+type PersonaRepository interface {
+	Get(ctx context.Context, id string) (models.Persona, error)
+}
+
+// Repositories groups the repositories the chat service works on
+type Repositories struct {
+	Sessions  SessionRepository
+	Messages  MessageRepository
+	Reactions ReactionRepository
+	Personas  PersonaRepository
+	Search    SearchRepository
+
+	transaction func(ctx context.Context, fn func(Repositories) error) error
+}
+
+// Transaction runs fn with repositories whose writes are committed together
+// if fn returns nil, and rolled back if it returns an error. Repositories
+// assembled by hand have no transactions and run fn with themselves.
+func (r Repositories) Transaction(ctx context.Context, fn func(Repositories) error) error {
+	if r.transaction == nil {
+		return fn(r)
+	}
+	return r.transaction(ctx, fn)
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markers around the matched words in the snippets of search hits. They are
+// control characters so that the caller can escape the snippet before
+// turning them into markup; they are removed from the text before it is
+// highlighted, so every marker in a snippet comes from a match.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// markers removes the highlight markers from text
+var markers = strings.NewReplacer(HighlightStart, "", HighlightEnd, "")
+
+// snippetRadius is how many bytes of context snippets keep around the first
+// match when searching without full-text support
+const snippetRadius = 80
+
+// SearchQuery selects the messages and session titles a user searches for.
+// Filters on the sender or the time only match messages; session titles
+// are matched on the time they were last updated.
+type SearchQuery struct {
+	Text          string
+	Sender        string     // "user" or "bot", empty for both and for titles
+	From          *time.Time // inclusive
+	To            *time.Time // exclusive
+	FavoritesOnly bool
+	Limit         int
+}
+
+// SearchHit is a message or a session title matching a search, best first
+type SearchHit struct {
+	SessionID    string
+	SessionTitle string
+	MessageID    string // empty when the title matched
+	Sender       string
+	Snippet      string // the matching text with the matches between the highlight markers
+	Rank         float64
+	Timestamp    time.Time
+}
+
+// searchTerms splits the text of a query into lower-case words
+func searchTerms(text string) []string {
+	return strings.Fields(lower(text))
+}
+
+// matchTerms returns how many times the terms occur in the text, or zero
+// unless all of them do
+func matchTerms(text string, terms []string) int {
+	text = lower(text)
+	count := 0
+	for _, term := range terms {
+		occurrences := strings.Count(text, term)
+		if occurrences == 0 {
+			return 0
+		}
+		count += occurrences
+	}
+	return count
+}
+
+// highlight returns the part of the text around the first match, with every
+// match of the terms between the highlight markers
+func highlight(text string, terms []string) string {
+	text = markers.Replace(text)
+	folded := lower(text)
+	type match struct{ start, end int }
+	var matches []match
+	for _, term := range terms {
+		for offset := 0; ; {
+			i := strings.Index(folded[offset:], term)
+			if i < 0 {
+				break
+			}
+			matches = append(matches, match{offset + i, offset + i + len(term)})
+			offset += i + len(term)
+		}
+	}
+	if len(matches) == 0 {
+		return text
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+
+	start, end := matches[0].start-snippetRadius, matches[0].end+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	position := start
+	for _, m := range matches {
+		if m.start < position || m.end > end {
+			continue
+		}
+		snippet.WriteString(text[position:m.start])
+		snippet.WriteString(HighlightStart + text[m.start:m.end] + HighlightEnd)
+		position = m.end
+	}
+	snippet.WriteString(text[position:end])
+	if end < len(text) {
+		snippet.WriteString("…")
+	}
+	return snippet.String()
+}
+
+// lower returns the text in lower case, leaving the letters whose lower case
+// is encoded in a different number of bytes, such as İ, as they are. Offsets
+// in the result then apply to the text.
+func lower(text string) string {
+	return strings.Map(func(r rune) rune {
+		if l := unicode.ToLower(r); utf8.RuneLen(l) == utf8.RuneLen(r) {
+			return l
+		}
+		return r
+	}, text)
+}
+
+// rankHits sorts the hits best first, newest first among equals, and keeps
+// at most limit of them
+func rankHits(hits []SearchHit, limit int) []SearchHit {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].Timestamp.After(hits[j].Timestamp)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "marks every match",
+			text: "Deploy the app, then deploy again",
+			want: "\x02Deploy\x03 the app, then \x02deploy\x03 again",
+		},
+		{
+			name: "keeps the text without matches",
+			text: "nothing here",
+			want: "nothing here",
+		},
+		{
+			name: "removes markers stored in the text",
+			text: "a \x03b\x02 deploy",
+			want: "a b \x02deploy\x03",
+		},
+		{
+			name: "trims long text around the first match",
+			text: long + "deploy " + long,
+			want: "…" + long[len(long)-snippetRadius:] + "\x02deploy\x03" + (" " + long)[:snippetRadius] + "…",
+		},
+		{
+			name: "ignores letters whose case changes their length",
+			text: "İstanbul DEPLOY",
+			want: "İstanbul \x02DEPLOY\x03",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, searchTerms("deploy")); got != tt.want {
+				t.Errorf("highlight(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTerms(t *testing.T) {
+	terms := searchTerms("Deploy Kubernetes")
+	if got := matchTerms("deploy to kubernetes, deploy", terms); got != 3 {
+		t.Errorf("matchTerms with every term = %d, want 3", got)
+	}
+	if got := matchTerms("deploy only", terms); got != 0 {
+		t.Errorf("matchTerms with a missing term = %d, want 0", got)
+	}
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"bytes"
+	"chatbot_backend/models"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,10 +12,12 @@ import (
 	"time"
 )
 
-// AIService interface defines the contract for AI services
+// AIService interface defines the contract for AI services.
+// The history is the session's conversation in chronological order and
+// ends with the user message that should be answered.
 type AIService interface {
-	SendMessage(message string) (string, error)
-	RegenerateMessage(message string) (string, error)
+	SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error)
+	RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error)
 }
 
 // OpenAIRequest represents the request structure for OpenAI API
@@ -21,7 +25,17 @@ type OpenAIRequest struct {
 	Model       string    `json:"model"`
 	Messages    []Message `json:"messages"`
 	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	Stop        []string  `json:"stop,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// OpenAIStreamOptions asks for the usage to be sent at the end of a stream
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Message represents a message in the OpenAI API format
@@ -30,9 +44,62 @@ type Message struct {
 	Content string `json:"content"`
 }
 
+// Roles used in the OpenAI API message format
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// System prompts used for normal and regenerated responses
+const (
+	DefaultSystemPrompt    = "You are a helpful assistant. Provide clear and useful responses to user questions."
+	RegenerateSystemPrompt = "You are a helpful assistant. " + regenerateInstruction
+	regenerateInstruction  = "Please provide a different perspective or approach to the user's question."
+)
+
+// BuildHistory maps stored session messages to the OpenAI message format.
+// Bot messages become assistant turns; typing placeholders, empty messages
+// and replies that are pending or failed are skipped.
+func BuildHistory(messages []models.Message) []Message {
+	history := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		if apiMessage, ok := toAPIMessage(msg); ok {
+			history = append(history, apiMessage)
+		}
+	}
+	return history
+}
+
+// toAPIMessage converts a stored message, reporting false if it should be skipped
+func toAPIMessage(msg models.Message) (Message, bool) {
+	if msg.IsTyping || msg.Content == "" ||
+		msg.Status == models.MessageStatusPending || msg.Status == models.MessageStatusFailed {
+		return Message{}, false
+	}
+
+	role := RoleUser
+	if msg.Sender == "bot" {
+		role = RoleAssistant
+	}
+	return Message{Role: role, Content: msg.Content}, true
+}
+
+// lastUserContent returns the content of the last user message in the history
+func lastUserContent(history []Message) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == RoleUser {
+			return history[i].Content
+		}
+	}
+	return ""
+}
+
 // OpenAIResponse represents the response structure from OpenAI API
 type OpenAIResponse struct {
+	Model   string    `json:"model"`
 	Choices []Choice  `json:"choices"`
+	Usage   Usage     `json:"usage"`
 	Error   *APIError `json:"error,omitempty"`
 }
 
@@ -47,11 +114,23 @@ type APIError struct {
 	Type    string `json:"type"`
 }
 
-// OpenAIService implements the AIService interface using OpenAI API
+// Defaults used when a provider does not configure its own
+const (
+	DefaultOpenAIModel = "gpt-3.5-turbo"
+	DefaultMaxTokens   = 1000
+)
+
+// OpenAIService implements the AIService interface using OpenAI API.
+// It works with any OpenAI compatible server, such as llama.cpp.
 type OpenAIService struct {
 	APIKey string
 	APIURL string
+	Model  string
 	Client *http.Client
+	// StreamClient is used for streamed responses, which Client's total
+	// timeout would cut off. Client is used if it is nil.
+	StreamClient *http.Client
+	Retry        RetryPolicy
 }
 
 // NewOpenAIService creates a new OpenAI service instance
@@ -59,90 +138,107 @@ func NewOpenAIService() *OpenAIService {
 	return &OpenAIService{
 		APIKey: os.Getenv("AI_API_KEY"),
 		APIURL: os.Getenv("AI_API_URL"),
+		Model:  DefaultOpenAIModel,
 		Client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		StreamClient: newStreamClient(30 * time.Second),
+		Retry:        DefaultRetryPolicy(),
 	}
 }
 
-// SendMessage sends a message to the AI service and returns the response
-func (s *OpenAIService) SendMessage(message string) (string, error) {
-	request := OpenAIRequest{
-		Model: "gpt-3.5-turbo",
-		Messages: []Message{
-			{Role: "system", Content: "You are a helpful assistant. Provide clear and useful responses to user questions."},
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   1000,
-		Temperature: 0.7,
-	}
+// SendMessage sends the conversation to the AI service and returns the response
+func (s *OpenAIService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature))
+}
 
-	return s.makeRequest(request)
+// RegenerateMessage regenerates a response for the last user message in the conversation
+func (s *OpenAIService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(true), history, settings, RegenerateTemperature))
 }
 
-// RegenerateMessage regenerates a response for the given message
-func (s *OpenAIService) RegenerateMessage(message string) (string, error) {
-	request := OpenAIRequest{
-		Model: "gpt-3.5-turbo",
-		Messages: []Message{
-			{Role: "system", Content: "You are a helpful assistant. Please provide a different perspective or approach to the user's question."},
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   1000,
-		Temperature: 0.8, // Slightly higher temperature for more variation
+// buildRequest builds an OpenAI request for the conversation
+func (s *OpenAIService) buildRequest(systemPrompt string, history []Message, settings GenerationSettings, temperature float64) OpenAIRequest {
+	return OpenAIRequest{
+		Model: settings.model(s.Model),
+		Messages: append([]Message{
+			{Role: RoleSystem, Content: systemPrompt},
+		}, history...),
+		MaxTokens:   settings.maxTokens(),
+		Temperature: settings.temperature(temperature),
+		TopP:        settings.TopP,
+		Stop:        settings.Stop,
 	}
-
-	return s.makeRequest(request)
 }
 
 // makeRequest makes an HTTP request to the OpenAI API
-func (s *OpenAIService) makeRequest(request OpenAIRequest) (string, error) {
-	jsonData, err := json.Marshal(request)
+func (s *OpenAIService) makeRequest(ctx context.Context, request OpenAIRequest) (Completion, error) {
+	resp, err := s.doRequest(ctx, request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return Completion{}, err
 	}
+	defer resp.Body.Close()
 
-	req, err := http.NewRequest("POST", s.APIURL, bytes.NewBuffer(jsonData))
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return Completion{}, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	var response OpenAIResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Completion{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+	if len(response.Choices) == 0 {
+		return Completion{}, fmt.Errorf("no response choices received")
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	return Completion{
+		Content: response.Choices[0].Message.Content,
+		Model:   firstNonEmpty(response.Model, request.Model),
+		Usage:   response.Usage,
+	}, nil
+}
+
+// doRequest sends the request to the OpenAI API, retrying transient failures,
+// and returns the response if the status is OK. The caller must close the
+// response body.
+func (s *OpenAIService) doRequest(ctx context.Context, request OpenAIRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var apiError APIError
-		if err := json.Unmarshal(body, &apiError); err != nil {
-			return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-		}
-		return "", fmt.Errorf("API error: %s", apiError.Message)
+	client := s.Client
+	if request.Stream {
+		client = streamingClient(s.StreamClient, s.Client)
 	}
+	return s.Retry.send(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.APIURL, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
 
-	var response OpenAIResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
-	}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+		return req, nil
+	}, parseOpenAIError)
+}
 
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response choices received")
+// parseOpenAIError extracts the error from an OpenAI error response
+func parseOpenAIError(body []byte) (string, string) {
+	var response OpenAIResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.Error == nil {
+		return "", ""
 	}
-
-	return response.Choices[0].Message.Content, nil
+	return response.Error.Type, response.Error.Message
 }
 
 // MockAIService is a mock implementation for testing purposes
-type MockAIService struct{}
+type MockAIService struct {
+	// WordDelay is the pause between words when streaming
+	WordDelay time.Duration
+}
 
 // NewMockAIService creates a new mock AI service
 func NewMockAIService() *MockAIService {
@@ -150,11 +246,30 @@ func NewMockAIService() *MockAIService {
 }
 
 // SendMessage returns a mock response
-func (m *MockAIService) SendMessage(message string) (string, error) {
-	return fmt.Sprintf("Mock response to: %s", message), nil
+func (m *MockAIService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return m.complete(history, settings, fmt.Sprintf("Mock response to: %s", lastUserContent(history))), nil
 }
 
 // RegenerateMessage returns a mock regenerated response
-func (m *MockAIService) RegenerateMessage(message string) (string, error) {
-	return fmt.Sprintf("Mock regenerated response to: %s", message), nil
+func (m *MockAIService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return m.complete(history, settings, fmt.Sprintf("Mock regenerated response to: %s", lastUserContent(history))), nil
+}
+
+// complete returns the mock response with an estimated usage
+func (m *MockAIService) complete(history []Message, settings GenerationSettings, content string) Completion {
+	usage := Usage{CompletionTokens: CountTokens(content)}
+	for _, msg := range history {
+		usage.PromptTokens += CountMessageTokens(msg)
+	}
+	return Completion{Content: content, Model: settings.model(ProviderMock), Usage: usage}
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
 }
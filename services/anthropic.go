@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Defaults for the Anthropic Messages API
+const (
+	DefaultAnthropicURL        = "https://api.anthropic.com/v1/messages"
+	DefaultAnthropicModel      = "claude-3-haiku-20240307"
+	anthropicVersion           = "2023-06-01"
+	anthropicEventStart        = "message_start"
+	anthropicEventDelta        = "content_block_delta"
+	anthropicEventMessageDelta = "message_delta"
+	anthropicEventError        = "error"
+	anthropicEventStop         = "message_stop"
+)
+
+// AnthropicRequest represents the request structure for the Anthropic Messages API
+type AnthropicRequest struct {
+	Model         string    `json:"model"`
+	System        string    `json:"system,omitempty"`
+	Messages      []Message `json:"messages"`
+	MaxTokens     int       `json:"max_tokens"`
+	Temperature   *float64  `json:"temperature,omitempty"`
+	TopP          *float64  `json:"top_p,omitempty"`
+	StopSequences []string  `json:"stop_sequences,omitempty"`
+	Stream        bool      `json:"stream,omitempty"`
+}
+
+// AnthropicResponse represents the response structure from the Anthropic Messages API
+type AnthropicResponse struct {
+	Model   string             `json:"model"`
+	Content []AnthropicContent `json:"content"`
+	Usage   AnthropicUsage     `json:"usage"`
+	Error   *APIError          `json:"error,omitempty"`
+}
+
+// AnthropicUsage represents the tokens used by an Anthropic response
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicContent represents a content block in an Anthropic response
+type AnthropicContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AnthropicStreamEvent represents an event of a streamed Anthropic response
+type AnthropicStreamEvent struct {
+	Type    string             `json:"type"`
+	Delta   AnthropicContent   `json:"delta"`
+	Message *AnthropicResponse `json:"message,omitempty"` // in message_start
+	Usage   *AnthropicUsage    `json:"usage,omitempty"`   // in message_delta
+	Error   *APIError          `json:"error,omitempty"`
+}
+
+// AnthropicService implements the AIService interface using the Anthropic Messages API
+type AnthropicService struct {
+	APIKey string
+	APIURL string
+	Model  string
+	Client *http.Client
+	// StreamClient is used for streamed responses, which Client's total
+	// timeout would cut off. Client is used if it is nil.
+	StreamClient *http.Client
+	Retry        RetryPolicy
+}
+
+// NewAnthropicService creates a new Anthropic service instance
+func NewAnthropicService(apiURL, apiKey, model string) *AnthropicService {
+	if apiURL == "" {
+		apiURL = DefaultAnthropicURL
+	}
+	if model == "" {
+		model = DefaultAnthropicModel
+	}
+	return &AnthropicService{
+		APIKey: apiKey,
+		APIURL: apiURL,
+		Model:  model,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		StreamClient: newStreamClient(30 * time.Second),
+		Retry:        DefaultRetryPolicy(),
+	}
+}
+
+// SendMessage sends the conversation to the Anthropic API and returns the response
+func (s *AnthropicService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature))
+}
+
+// RegenerateMessage regenerates a response for the last user message in the conversation
+func (s *AnthropicService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(true), history, settings, RegenerateTemperature))
+}
+
+// StreamMessage streams the response to the conversation from the Anthropic API
+func (s *AnthropicService) StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	request := s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature)
+	request.Stream = true
+
+	resp, err := s.doRequest(ctx, request)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	completion := Completion{Model: request.Model}
+	result := func() Completion {
+		completion.Content = content.String()
+		return completion
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return result(), fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+
+		switch event.Type {
+		case anthropicEventStart:
+			if event.Message != nil {
+				completion.Model = firstNonEmpty(event.Message.Model, completion.Model)
+				completion.Usage.PromptTokens = event.Message.Usage.InputTokens
+			}
+		case anthropicEventDelta:
+			if event.Delta.Text == "" {
+				continue
+			}
+			content.WriteString(event.Delta.Text)
+			if err := onDelta(event.Delta.Text); err != nil {
+				return result(), err
+			}
+		case anthropicEventMessageDelta:
+			// The output tokens are cumulative
+			if event.Usage != nil {
+				completion.Usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case anthropicEventError:
+			if event.Error != nil {
+				return result(), fmt.Errorf("API error: %s", event.Error.Message)
+			}
+			return result(), fmt.Errorf("API error in stream")
+		case anthropicEventStop:
+			return result(), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result(), fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return result(), ErrStreamIncomplete
+}
+
+// buildRequest converts the conversation to the Anthropic format.
+// System messages are moved to the system prompt and consecutive messages
+// of the same role are merged, since the API requires alternating turns
+// that start with the user.
+func (s *AnthropicService) buildRequest(systemPrompt string, history []Message, settings GenerationSettings, temperature float64) AnthropicRequest {
+	system := []string{systemPrompt}
+	messages := make([]Message, 0, len(history))
+
+	for _, msg := range history {
+		if msg.Role == RoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		if len(messages) == 0 && msg.Role != RoleUser {
+			continue
+		}
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == msg.Role {
+			messages[last].Content += "\n\n" + msg.Content
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	return AnthropicRequest{
+		Model:         settings.model(s.Model),
+		System:        strings.Join(system, "\n\n"),
+		Messages:      messages,
+		MaxTokens:     settings.maxTokens(),
+		Temperature:   settings.temperature(temperature),
+		TopP:          settings.TopP,
+		StopSequences: settings.Stop,
+	}
+}
+
+// makeRequest makes an HTTP request to the Anthropic API
+func (s *AnthropicService) makeRequest(ctx context.Context, request AnthropicRequest) (Completion, error) {
+	resp, err := s.doRequest(ctx, request)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Completion{}, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response AnthropicResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Completion{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var content strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return Completion{}, fmt.Errorf("no response content received")
+	}
+
+	return Completion{
+		Content: content.String(),
+		Model:   firstNonEmpty(response.Model, request.Model),
+		Usage: Usage{
+			PromptTokens:     response.Usage.InputTokens,
+			CompletionTokens: response.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// doRequest sends the request to the Anthropic API, retrying transient
+// failures, and returns the response if the status is OK. The caller must
+// close the response body.
+func (s *AnthropicService) doRequest(ctx context.Context, request AnthropicRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := s.Client
+	if request.Stream {
+		client = streamingClient(s.StreamClient, s.Client)
+	}
+	return s.Retry.send(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.APIURL, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-api-key", s.APIKey)
+		req.Header.Set("anthropic-version", anthropicVersion)
+		return req, nil
+	}, parseAnthropicError)
+}
+
+// parseAnthropicError extracts the error from an Anthropic error response
+func parseAnthropicError(body []byte) (string, string) {
+	var response AnthropicResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.Error == nil {
+		return "", ""
+	}
+	return response.Error.Type, response.Error.Message
+}
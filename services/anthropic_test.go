@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordedRequest is the last request a recording upstream received
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// decode unmarshals the recorded body into v
+func (r *recordedRequest) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("decode request %s: %v", r.body, err)
+	}
+}
+
+// newRecordingUpstream returns an upstream that answers every request with
+// the status and body and records the last request it received
+func newRecordingUpstream(t *testing.T, status int, body string) (*httptest.Server, *recordedRequest) {
+	t.Helper()
+
+	recorded := &recordedRequest{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorded.header = r.Header.Clone()
+		recorded.body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, recorded
+}
+
+// newTestAnthropic returns an Anthropic service that sends its requests to
+// the upstream without retrying
+func newTestAnthropic(upstream *httptest.Server) *AnthropicService {
+	service := NewAnthropicService(upstream.URL, "test-key", "")
+	service.Client = upstream.Client()
+	service.Retry = RetryPolicy{MaxAttempts: 1}
+	return service
+}
+
+func TestAnthropicSendMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, `{
+		"model": "claude-3-opus-20240229",
+		"content": [
+			{"type": "text", "text": "Bonjour"},
+			{"type": "tool_use"},
+			{"type": "text", "text": " !"}
+		],
+		"usage": {"input_tokens": 25, "output_tokens": 4}
+	}`)
+
+	temperature, topP := 0.2, 0.9
+	settings := GenerationSettings{
+		SystemPrompt: "Be brief",
+		Model:        "claude-3-opus-20240229",
+		Temperature:  &temperature,
+		TopP:         &topP,
+		Stop:         []string{"END"},
+	}
+	history := []Message{
+		{Role: RoleAssistant, Content: "Welcome"},
+		{Role: RoleSystem, Content: "Earlier summary"},
+		{Role: RoleUser, Content: "Hi"},
+		{Role: RoleUser, Content: "Are you there?"},
+		{Role: RoleAssistant, Content: "Yes"},
+		{Role: RoleUser, Content: "Answer in French"},
+	}
+
+	completion, err := newTestAnthropic(upstream).SendMessage(context.Background(), history, settings)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if key, version := recorded.header.Get("x-api-key"), recorded.header.Get("anthropic-version"); key != "test-key" || version != anthropicVersion {
+		t.Errorf("headers x-api-key %q, anthropic-version %q; want the key and %s", key, version, anthropicVersion)
+	}
+	if auth := recorded.header.Get("Authorization"); auth != "" {
+		t.Errorf("sent Authorization %q, want the key only in x-api-key", auth)
+	}
+
+	var request AnthropicRequest
+	recorded.decode(t, &request)
+	if request.Model != settings.Model || request.MaxTokens != DefaultMaxTokens || request.Stream {
+		t.Errorf("request model %q, max tokens %d, stream %v", request.Model, request.MaxTokens, request.Stream)
+	}
+	if request.System != "Be brief\n\nEarlier summary" {
+		t.Errorf("system = %q, want the system prompt and the system messages", request.System)
+	}
+	if request.Temperature == nil || *request.Temperature != temperature || request.TopP == nil || *request.TopP != topP {
+		t.Errorf("temperature %v, top p %v; want %v and %v", request.Temperature, request.TopP, temperature, topP)
+	}
+	if fmt.Sprint(request.StopSequences) != "[END]" {
+		t.Errorf("stop sequences = %v, want [END]", request.StopSequences)
+	}
+
+	// The turns alternate and start with the user
+	want := []Message{
+		{Role: RoleUser, Content: "Hi\n\nAre you there?"},
+		{Role: RoleAssistant, Content: "Yes"},
+		{Role: RoleUser, Content: "Answer in French"},
+	}
+	if fmt.Sprint(request.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %+v, want %+v", request.Messages, want)
+	}
+
+	if completion.Content != "Bonjour !" || completion.Model != "claude-3-opus-20240229" {
+		t.Errorf("completion = %q from %q, want the text blocks from the response model", completion.Content, completion.Model)
+	}
+	if completion.Usage != (Usage{PromptTokens: 25, CompletionTokens: 4}) {
+		t.Errorf("usage = %+v, want the input and output tokens", completion.Usage)
+	}
+}
+
+func TestAnthropicRegenerateMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, `{"content":[{"type":"text","text":"Hello"}]}`)
+
+	completion, err := newTestAnthropic(upstream).RegenerateMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+	if err != nil {
+		t.Fatalf("RegenerateMessage: %v", err)
+	}
+
+	var request AnthropicRequest
+	recorded.decode(t, &request)
+	if request.System != RegenerateSystemPrompt || request.Temperature == nil || *request.Temperature != RegenerateTemperature {
+		t.Errorf("system %q at temperature %v, want the regeneration prompt and temperature", request.System, request.Temperature)
+	}
+	if completion.Model != DefaultAnthropicModel || request.Model != DefaultAnthropicModel {
+		t.Errorf("requested %q and got %q, want the default model", request.Model, completion.Model)
+	}
+}
+
+func TestAnthropicSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int // status of the provider error, 0 for other errors
+		wantErr    string
+	}{
+		{
+			name:       "error response",
+			status:     http.StatusUnauthorized,
+			body:       `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "invalid x-api-key",
+		},
+		{
+			name:    "no text content",
+			status:  http.StatusOK,
+			body:    `{"content":[{"type":"tool_use"}]}`,
+			wantErr: "no response content received",
+		},
+		{
+			name:    "malformed response",
+			status:  http.StatusOK,
+			body:    `{"content":`,
+			wantErr: "failed to unmarshal response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := newRecordingUpstream(t, tt.status, tt.body)
+
+			_, err := newTestAnthropic(upstream).SendMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("SendMessage error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantStatus != 0 {
+				providerErr, ok := AsProviderError(err)
+				if !ok || providerErr.StatusCode != tt.wantStatus || providerErr.Type != "authentication_error" {
+					t.Errorf("SendMessage error = %#v, want a provider error with status %d", err, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, strings.Join([]string{
+		"event: message_start",
+		`data: {"type":"message_start","message":{"model":"claude-3-haiku-20240307","content":[],"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		"",
+		"event: ping",
+		`data: {"type":"ping"}`,
+		"",
+		"event: content_block_delta",
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		"",
+		"event: content_block_delta",
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", world"}}`,
+		"",
+		"event: message_delta",
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`,
+		"",
+		"event: message_stop",
+		`data: {"type":"message_stop"}`,
+		"",
+		`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":" after the end"}}`,
+	}, "\n"))
+
+	var deltas []string
+	completion, err := newTestAnthropic(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, collectDeltas(&deltas))
+	if err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+
+	var request AnthropicRequest
+	recorded.decode(t, &request)
+	if !request.Stream {
+		t.Error("request did not ask for a stream")
+	}
+	if got := strings.Join(deltas, "|"); got != "Hello|, world" {
+		t.Errorf("deltas = %q, want Hello and , world", got)
+	}
+	if completion.Content != "Hello, world" || completion.Model != "claude-3-haiku-20240307" {
+		t.Errorf("completion = %q from %q", completion.Content, completion.Model)
+	}
+	if completion.Usage != (Usage{PromptTokens: 25, CompletionTokens: 6}) {
+		t.Errorf("usage = %+v, want the input tokens of the start and the output tokens of the last delta", completion.Usage)
+	}
+}
+
+func TestAnthropicStreamMessageError(t *testing.T) {
+	upstream, _ := newRecordingUpstream(t, http.StatusOK, strings.Join([]string{
+		`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hello"}}`,
+		`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	}, "\n"))
+
+	var deltas []string
+	completion, err := newTestAnthropic(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, collectDeltas(&deltas))
+	if err == nil || err.Error() != "API error: Overloaded" {
+		t.Fatalf("StreamMessage error = %v, want the error event", err)
+	}
+	if completion.Content != "Hello" {
+		t.Errorf("partial completion = %q, want the text sent before the error", completion.Content)
+	}
+}
+
+func TestAnthropicStreamMessageTruncated(t *testing.T) {
+	upstream, _ := newRecordingUpstream(t, http.StatusOK, strings.Join([]string{
+		`data: {"type":"message_start","message":{"model":"claude-3-haiku-20240307","usage":{"input_tokens":25}}}`,
+		`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hello"}}`,
+	}, "\n"))
+
+	completion, err := newTestAnthropic(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, func(string) error { return nil })
+	if !errors.Is(err, ErrStreamIncomplete) || completion.Content != "Hello" {
+		t.Fatalf("StreamMessage = %q, %v; want the partial text and ErrStreamIncomplete without message_stop", completion.Content, err)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every personal API key, telling it apart from access tokens
+const APIKeyPrefix = "sk-"
+
+// apiKeyDisplayLength is the length of the key prefix shown in listings
+const apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// Scopes that can be granted to API keys
+const (
+	ScopeChatWrite     = "chat:write"     // send and regenerate messages
+	ScopeSessionsRead  = "sessions:read"  // read sessions and messages
+	ScopeSessionsWrite = "sessions:write" // create, update and delete sessions
+	ScopePersonasRead  = "personas:read"  // read personas
+	ScopePersonasWrite = "personas:write" // create, update and delete personas
+	ScopeUsageRead     = "usage:read"     // read token usage and costs
+)
+
+// Scopes lists every scope an API key can be granted
+var Scopes = []string{
+	ScopeChatWrite,
+	ScopeSessionsRead,
+	ScopeSessionsWrite,
+	ScopePersonasRead,
+	ScopePersonasWrite,
+	ScopeUsageRead,
+}
+
+// lastUsedResolution limits how often the last used time of a key is written
+const lastUsedResolution = time.Minute
+
+// ErrInvalidAPIKey is returned for unknown, revoked or expired API keys
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyService authenticates requests made with personal API keys
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyService creates a new API key service instance
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// NewAPIKey generates a random API key and returns it with the hash to store
+// and the prefix shown in listings
+func NewAPIKey() (string, string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashToken(key), key[:apiKeyDisplayLength], nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an access token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ValidScope reports whether the scope can be granted to an API key
+func ValidScope(scope string) bool {
+	return contains(Scopes, scope)
+}
+
+// HasScope reports whether the key was granted the scope
+func HasScope(key *models.APIKey, scope string) bool {
+	return contains(key.Scopes, scope)
+}
+
+// Authenticate returns the active API key matching the given key and records
+// that it was used
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	db := s.db.WithContext(ctx)
+
+	var apiKey models.APIKey
+	if err := db.First(&apiKey, "key_hash = ?", HashToken(key)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Scripts may call in a tight loop; a coarse last used time is enough
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		if err := db.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &apiKey, nil
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"context"
+)
+
+// Branch is a path through a session's message tree, from the first message
+// to a message without replies
+type Branch struct {
+	LastMessage models.Message `json:"lastMessage"`
+	Length      int            `json:"length"` // number of messages on the path
+	Active      bool           `json:"active"`
+}
+
+// ListBranches returns the branches of a session, oldest first
+func (s *ChatService) ListBranches(ctx context.Context, userID, sessionID string) ([]Branch, error) {
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := newMessageTree(messages)
+	active := tree.activeLeaf(session)
+	leaves, err := s.withReactions(ctx, tree.leaves())
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]Branch, 0)
+	for _, leaf := range leaves {
+		branches = append(branches, Branch{
+			LastMessage: leaf,
+			Length:      len(tree.path(leaf.ID)),
+			Active:      leaf.ID == active,
+		})
+	}
+	return branches, nil
+}
+
+// SwitchBranch makes the branch through the message active, following the
+// newest replies down from it. It returns the messages of the branch.
+func (s *ChatService) SwitchBranch(ctx context.Context, userID, sessionID, messageID string) ([]models.Message, error) {
+	if err := s.CheckSession(ctx, userID, sessionID); err != nil {
+		return nil, err
+	}
+	messages, err := s.messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := newMessageTree(messages)
+	if _, ok := tree.byID[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	leaf := tree.latestLeaf(messageID)
+	if err := s.sessions.SetActiveMessage(ctx, sessionID, leaf); err != nil {
+		return nil, err
+	}
+	return s.withReactions(ctx, tree.numbered(tree.path(leaf)))
+}
+
+// Version is one of the versions of a turn: a bot reply and its
+// regenerations, or a user message and its edits
+type Version struct {
+	Number   int            `json:"number"` // 1 for the first version
+	Message  models.Message `json:"message"`
+	Selected bool           `json:"selected"` // on the active branch
+}
+
+// GetVersions returns the versions of the turn of a message in one of the
+// user's sessions, oldest first
+func (s *ChatService) GetVersions(ctx context.Context, userID, messageID string) ([]Version, error) {
+	message, err := s.getMessage(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.getSession(ctx, userID, message.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.messages.List(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := newMessageTree(messages)
+	active := make(map[string]bool)
+	for _, onPath := range tree.path(tree.activeLeaf(session)) {
+		active[onPath.ID] = true
+	}
+
+	numbered, err := s.withReactions(ctx, tree.numbered(tree.versions(message)))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0)
+	for i, version := range numbered {
+		versions = append(versions, Version{
+			Number:   i + 1,
+			Message:  version,
+			Selected: active[version.ID],
+		})
+	}
+	return versions, nil
+}
+
+// SelectVersion makes a version of a turn part of the active branch, so that
+// later turns use it as history. It follows the newest replies down from the
+// version and returns the messages of the branch.
+func (s *ChatService) SelectVersion(ctx context.Context, userID, messageID string) ([]models.Message, error) {
+	message, err := s.getMessage(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.SwitchBranch(ctx, userID, message.SessionID, message.ID)
+}
+
+// messageTree indexes the messages of a session by ID and by parent
+type messageTree struct {
+	messages []models.Message
+	byID     map[string]models.Message
+	children map[string][]models.Message // by parent ID, "" for first messages
+}
+
+// newMessageTree indexes messages given in chronological order
+func newMessageTree(messages []models.Message) *messageTree {
+	tree := &messageTree{
+		messages: messages,
+		byID:     make(map[string]models.Message, len(messages)),
+		children: make(map[string][]models.Message),
+	}
+	for _, message := range messages {
+		tree.byID[message.ID] = message
+	}
+	for _, message := range messages {
+		parentID := tree.parentID(message)
+		tree.children[parentID] = append(tree.children[parentID], message)
+	}
+	return tree
+}
+
+// parentID returns the ID of the message's parent, or "" if it has none in
+// the tree
+func (t *messageTree) parentID(message models.Message) string {
+	if message.ParentID == nil {
+		return ""
+	}
+	if _, ok := t.byID[*message.ParentID]; !ok {
+		return ""
+	}
+	return *message.ParentID
+}
+
+// activeLeaf returns the ID of the last message of the session's active
+// branch. Without one, the branch of the newest message is active.
+func (t *messageTree) activeLeaf(session models.Session) string {
+	if session.ActiveMessageID != nil {
+		if _, ok := t.byID[*session.ActiveMessageID]; ok {
+			return *session.ActiveMessageID
+		}
+	}
+	if len(t.messages) == 0 {
+		return ""
+	}
+	return t.messages[len(t.messages)-1].ID
+}
+
+// path returns the messages from the first message to the one with the ID
+func (t *messageTree) path(id string) []models.Message {
+	path := make([]models.Message, 0)
+	for id != "" && len(path) < len(t.byID) {
+		message, ok := t.byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, message)
+		id = t.parentID(message)
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// latestLeaf follows the newest replies down from the message with the ID
+// and returns the ID of the message reached
+func (t *messageTree) latestLeaf(id string) string {
+	for steps := 0; steps < len(t.byID); steps++ {
+		children := t.children[id]
+		if len(children) == 0 {
+			break
+		}
+		id = children[len(children)-1].ID
+	}
+	return id
+}
+
+// answered returns the user message the bot message replies to, or
+// ErrNoUserMessage
+func (t *messageTree) answered(reply models.Message) (models.Message, error) {
+	userMessage, ok := t.byID[t.parentID(reply)]
+	if reply.Sender != "bot" || !ok || userMessage.Sender != "user" {
+		return models.Message{}, ErrNoUserMessage
+	}
+	return userMessage, nil
+}
+
+// versions returns the siblings of the message from the same sender,
+// including the message, oldest first
+func (t *messageTree) versions(message models.Message) []models.Message {
+	var versions []models.Message
+	for _, sibling := range t.children[t.parentID(message)] {
+		if sibling.Sender == message.Sender {
+			versions = append(versions, sibling)
+		}
+	}
+	return versions
+}
+
+// parentKey returns the ID of the message's parent, or "" for a first message
+func parentKey(message models.Message) string {
+	if message.ParentID == nil {
+		return ""
+	}
+	return *message.ParentID
+}
+
+// parentIDs returns the parent IDs of the messages, "" for first messages
+func parentIDs(messages []models.Message) []string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = parentKey(message)
+	}
+	return ids
+}
+
+// numberVersions returns a copy of the messages with their version numbers
+// set, given every reply to their parents in chronological order
+func numberVersions(messages, replies []models.Message) []models.Message {
+	byParent := make(map[string][]models.Message)
+	for _, reply := range replies {
+		byParent[parentKey(reply)] = append(byParent[parentKey(reply)], reply)
+	}
+
+	result := make([]models.Message, len(messages))
+	for i, message := range messages {
+		version, count := 0, 0
+		for _, sibling := range byParent[parentKey(message)] {
+			if sibling.Sender != message.Sender {
+				continue
+			}
+			count++
+			if sibling.ID == message.ID {
+				version = count
+			}
+		}
+		if version > 0 {
+			message.Version, message.VersionCount = version, count
+		}
+		result[i] = message
+	}
+	return result
+}
+
+// numbered returns a copy of the messages with their version numbers set
+func (t *messageTree) numbered(messages []models.Message) []models.Message {
+	result := make([]models.Message, len(messages))
+	for i, message := range messages {
+		versions := t.versions(message)
+		for j, version := range versions {
+			if version.ID == message.ID {
+				message.Version = j + 1
+				message.VersionCount = len(versions)
+			}
+		}
+		result[i] = message
+	}
+	return result
+}
+
+// leaves returns the messages without replies, oldest first
+func (t *messageTree) leaves() []models.Message {
+	var leaves []models.Message
+	for _, message := range t.messages {
+		if len(t.children[message.ID]) == 0 {
+			leaves = append(leaves, message)
+		}
+	}
+	return leaves
+}
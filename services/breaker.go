@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+// Circuit breaker states
+const (
+	BreakerClosed   BreakerState = "closed"    // calls go through
+	BreakerOpen     BreakerState = "open"      // calls fail fast until the timeout passes
+	BreakerHalfOpen BreakerState = "half_open" // a single probe call decides the next state
+)
+
+// ErrCircuitOpen is returned when a provider's circuit breaker rejects a call
+var ErrCircuitOpen = errors.New("AI provider is unavailable (circuit breaker open)")
+
+// CircuitBreaker stops calling a provider after repeated failures.
+// It opens after FailureThreshold consecutive failures and lets a single probe
+// call through once OpenTimeout has passed; the probe closes it again on
+// success or reopens it on failure. A threshold of zero disables the breaker.
+type CircuitBreaker struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// now returns the current time; tests replace it to avoid real waits
+	now func() time.Time
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"openedAt,omitempty"`
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		state:            BreakerClosed,
+	}
+}
+
+// Allow reports whether a call may be made, returning ErrCircuitOpen if not
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed call.
+// Errors caused by the request itself, such as a prompt that is too long,
+// do not count against the provider.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || !isProviderFailure(err) {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.FailureThreshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || b.failures >= b.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = b.clock()
+	}
+}
+
+// Release ends an allowed call without an outcome, such as a call the caller
+// cancelled, so it counts neither for nor against the provider
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Status returns the current state of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:    b.currentState(),
+		Failures: b.failures,
+	}
+	if status.State != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// currentState moves an open breaker to half-open once the timeout has passed.
+// The caller must hold the lock.
+func (b *CircuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probing = false
+	}
+	return b.state
+}
+
+// clock returns the current time
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// isProviderFailure reports whether an error means the provider is unhealthy,
+// as opposed to rejecting this particular request
+func isProviderFailure(err error) bool {
+	providerErr, ok := AsProviderError(err)
+	if !ok || providerErr.Retryable {
+		return true
+	}
+	switch providerErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		// A bad key or URL fails every call until the configuration is fixed
+		return true
+	}
+	return providerErr.StatusCode < 400 || providerErr.StatusCode >= 500
+}
+
+// guardedService wraps an AI service with a circuit breaker
+type guardedService struct {
+	AIService
+	breaker *CircuitBreaker
+}
+
+// SendMessage sends the conversation unless the breaker is open
+func (s *guardedService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return Completion{}, err
+	}
+	completion, err := s.AIService.SendMessage(ctx, history, settings)
+	s.record(ctx, err)
+	return completion, err
+}
+
+// RegenerateMessage regenerates the response unless the breaker is open
+func (s *guardedService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return Completion{}, err
+	}
+	completion, err := s.AIService.RegenerateMessage(ctx, history, settings)
+	s.record(ctx, err)
+	return completion, err
+}
+
+// StreamMessage streams the response unless the breaker is open.
+// A stream stopped by the caller counts as a success, since the provider was
+// answering.
+func (s *guardedService) StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	if err := s.breaker.Allow(); err != nil {
+		return Completion{}, err
+	}
+
+	var callbackErr error
+	completion, err := StreamMessage(ctx, s.AIService, history, settings, func(delta string) error {
+		callbackErr = onDelta(delta)
+		return callbackErr
+	})
+	if callbackErr != nil {
+		s.breaker.Record(nil)
+	} else {
+		s.record(ctx, err)
+	}
+	return completion, err
+}
+
+// record updates the breaker unless the call was cancelled by the caller
+func (s *guardedService) record(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		s.breaker.Release()
+		return
+	}
+	s.breaker.Record(err)
+}
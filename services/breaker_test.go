@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	breaker := NewCircuitBreaker(2, 30*time.Second)
+	breaker.now = clock.Now
+	outage := &ProviderError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+
+	for i := 0; i < 2; i++ {
+		if err := breaker.Allow(); err != nil {
+			t.Fatalf("call %d: Allow = %v before the threshold", i+1, err)
+		}
+		breaker.Record(outage)
+	}
+	if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow = %v after %d failures, want ErrCircuitOpen", err, 2)
+	}
+
+	clock.Advance(29 * time.Second)
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("state = %s before the timeout, want open", state)
+	}
+
+	// Once the timeout passed, a single probe goes through and its failure
+	// opens the breaker again
+	clock.Advance(time.Second)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("probe: Allow = %v", err)
+	}
+	if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow during the probe = %v, want ErrCircuitOpen", err)
+	}
+	breaker.Record(outage)
+	if status := breaker.Status(); status.State != BreakerOpen || !status.OpenedAt.Equal(clock.Now()) {
+		t.Fatalf("status after a failed probe = %+v, want open since now", status)
+	}
+
+	// A successful probe closes it
+	clock.Advance(30 * time.Second)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("second probe: Allow = %v", err)
+	}
+	breaker.Record(nil)
+	if status := breaker.Status(); status.State != BreakerClosed || status.Failures != 0 {
+		t.Fatalf("status after a successful probe = %+v, want closed", status)
+	}
+}
+
+func TestCircuitBreakerIgnoresRequestErrors(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = newFakeClock().Now
+
+	breaker.Record(&ProviderError{StatusCode: http.StatusBadRequest, Message: "prompt is too long"})
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("Allow = %v after a request error, want the breaker closed", err)
+	}
+
+	breaker.Record(&ProviderError{StatusCode: http.StatusUnauthorized})
+	if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow = %v after an authentication error, want ErrCircuitOpen", err)
+	}
+}
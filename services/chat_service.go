@@ -2,162 +2,758 @@ package services
 
 import (
 	"chatbot_backend/models"
+	"chatbot_backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
-// ChatService handles chat-related business logic
+// Errors returned by the chat service
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrPersonaNotFound = errors.New("persona not found")
+	// ErrNoUserMessage is returned when regenerating or retrying a reply
+	// that does not answer a user message
+	ErrNoUserMessage = errors.New("no user message to regenerate")
+	// ErrNotEditable is returned when editing a message the user did not send
+	ErrNotEditable = errors.New("only user messages can be edited")
+	// ErrNotRetryable is returned when retrying a message that is not a
+	// failed or abandoned bot reply
+	ErrNotRetryable = errors.New("message cannot be retried")
+)
+
+// abandonedAfter is how long a reply can stay pending before it is
+// considered abandoned, for example by a server restart, and can be retried
+const abandonedAfter = 10 * time.Minute
+
+// SettingsError is returned when a session's generation settings are invalid
+type SettingsError struct {
+	Err error
+}
+
+func (e *SettingsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SettingsError) Unwrap() error {
+	return e.Err
+}
+
+// Exchange is a user message about to be answered, with the session, the
+// conversation history up to and including the message and the pending reply
+type Exchange struct {
+	Session     models.Session
+	UserMessage models.Message
+	History     []models.Message
+	Reply       models.Message
+}
+
+// Regeneration is a bot reply about to get a new version, with the session
+// and the conversation history up to and including the user message it
+// answered
+type Regeneration struct {
+	Session  models.Session
+	Original models.Message
+	History  []models.Message
+	Versions int // number of versions of the reply so far
+}
+
+// ChatService handles chat-related business logic. Sessions are always
+// accessed on behalf of their owner; sessions of other users are reported
+// as missing.
 type ChatService struct {
-	db *gorm.DB
+	repos          repository.Repositories
+	sessions       repository.SessionRepository
+	messages       repository.MessageRepository
+	reactions      repository.ReactionRepository
+	personas       repository.PersonaRepository
+	search         repository.SearchRepository
+	registry       *Registry
+	contextBuilder *ContextBuilder
 }
 
 // NewChatService creates a new chat service instance
-func NewChatService(db *gorm.DB) *ChatService {
-	return &ChatService{db: db}
+func NewChatService(repos repository.Repositories, registry *Registry, contextBuilder *ContextBuilder) *ChatService {
+	return &ChatService{
+		repos:          repos,
+		sessions:       repos.Sessions,
+		messages:       repos.Messages,
+		reactions:      repos.Reactions,
+		personas:       repos.Personas,
+		search:         repos.Search,
+		registry:       registry,
+		contextBuilder: contextBuilder,
+	}
 }
 
-// CreateSession creates a new chat session
-func (s *ChatService) CreateSession(title string) (*models.Session, error) {
-	session := &models.Session{
-		ID:         uuid.New().String(),
-		Title:      title,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-		IsFavorite: false,
-	}
+// HasProvider reports whether a provider requested by a client exists; an
+// empty name selects the default
+func (s *ChatService) HasProvider(name string) bool {
+	return name == "" || s.registry.Has(name)
+}
 
-	if err := s.db.Create(session).Error; err != nil {
-		return nil, err
+// GetSessions retrieves a page of the user's sessions, most recently updated
+// first
+func (s *ChatService) GetSessions(ctx context.Context, userID string, request PageRequest) (Page[models.Session], error) {
+	page, err := request.page()
+	if err != nil {
+		return Page[models.Session]{}, err
 	}
 
-	return session, nil
+	// One more than the limit tells whether there is a next page
+	fetch := page
+	fetch.Limit++
+	sessions, err := s.sessions.ListByUser(ctx, userID, fetch)
+	if err != nil {
+		return Page[models.Session]{}, err
+	}
+	return sessionPage(sessions, page), nil
 }
 
-// GetSession retrieves a session by ID
-func (s *ChatService) GetSession(sessionID string) (*models.Session, error) {
-	var session models.Session
-	if err := s.db.Preload("Messages").
-		First(&session, "id = ?", sessionID).Error; err != nil {
-		return nil, err
+// GetSession retrieves a session with its persona and the latest messages of
+// its active branch, at most limit of them. It returns the cursor of the
+// older messages, or "" if there are none.
+func (s *ChatService) GetSession(ctx context.Context, userID, sessionID string, limit int) (models.Session, string, error) {
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return session, "", err
 	}
-	return &session, nil
-}
 
-// GetSessions retrieves all sessions
-func (s *ChatService) GetSessions() ([]models.Session, error) {
-	var sessions []models.Session
-	if err := s.db.Order("updated_at DESC").Find(&sessions).Error; err != nil {
-		return nil, err
+	page, err := PageRequest{Limit: limit}.page()
+	if err != nil {
+		return session, "", err
 	}
-	return sessions, nil
+	messages, err := s.branchMessages(ctx, session, false, page)
+	if err != nil {
+		return session, "", err
+	}
+	session.Messages = messages.Items
+	s.loadPersona(ctx, &session)
+	return session, messages.NextCursor, nil
 }
 
-// UpdateSession updates a session
-func (s *ChatService) UpdateSession(sessionID string, title string, isFavorite *bool) (*models.Session, error) {
-	var session models.Session
-	if err := s.db.First(&session, "id = ?", sessionID).Error; err != nil {
-		return nil, err
+// CreateSession validates and creates a new session for session.UserID
+func (s *ChatService) CreateSession(ctx context.Context, session *models.Session) error {
+	if err := s.validateSettings(ctx, session); err != nil {
+		return err
 	}
 
-	if title != "" {
-		session.Title = title
+	session.ID = uuid.New().String()
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = session.CreatedAt
+	return s.sessions.Create(ctx, session)
+}
+
+// UpdateSession applies the changes to a session and saves it if its
+// settings are still valid
+func (s *ChatService) UpdateSession(ctx context.Context, userID, sessionID string, apply func(*models.Session)) (models.Session, error) {
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return session, err
 	}
-	if isFavorite != nil {
-		session.IsFavorite = *isFavorite
+
+	apply(&session)
+	if err := s.validateSettings(ctx, &session); err != nil {
+		return session, err
 	}
+
 	session.UpdatedAt = time.Now()
+	return session, s.sessions.Save(ctx, &session)
+}
 
-	if err := s.db.Save(&session).Error; err != nil {
-		return nil, err
+// ToggleFavorite toggles the favorite status of a session
+func (s *ChatService) ToggleFavorite(ctx context.Context, userID, sessionID string) (models.Session, error) {
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return session, err
 	}
 
-	return &session, nil
+	session.IsFavorite = !session.IsFavorite
+	session.UpdatedAt = time.Now()
+	return session, s.sessions.Save(ctx, &session)
 }
 
 // DeleteSession deletes a session and its messages
-func (s *ChatService) DeleteSession(sessionID string) error {
-	// Delete associated messages first
-	if err := s.db.Where("session_id = ?", sessionID).Delete(&models.Message{}).Error; err != nil {
+func (s *ChatService) DeleteSession(ctx context.Context, userID, sessionID string) error {
+	if err := s.CheckSession(ctx, userID, sessionID); err != nil {
 		return err
 	}
+	return s.sessions.Delete(ctx, sessionID)
+}
 
-	// Delete session
-	if err := s.db.Where("id = ?", sessionID).Delete(&models.Session{}).Error; err != nil {
-		return err
+// GetMessages retrieves a page of the messages of a session's active branch,
+// or of every branch if all is set, in chronological order, numbered and
+// with their reactions.
+func (s *ChatService) GetMessages(ctx context.Context, userID, sessionID string, all bool, request PageRequest) (Page[models.Message], error) {
+	page, err := request.page()
+	if err != nil {
+		return Page[models.Message]{}, err
+	}
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return Page[models.Message]{}, err
 	}
+	return s.branchMessages(ctx, session, all, page)
+}
 
-	return nil
+// branchMessages returns a page of the messages of the session's active branch,
+// or of every branch if all is set
+func (s *ChatService) branchMessages(ctx context.Context, session models.Session, all bool, page repository.Page) (Page[models.Message], error) {
+	var leafID string
+	if !all {
+		var err error
+		if leafID, err = s.activeLeaf(ctx, session); err != nil || leafID == "" {
+			return messagePage(nil, page), err
+		}
+	}
+
+	// One more than the limit tells whether there is a next page
+	fetch := page
+	fetch.Limit++
+	messages, err := s.messages.ListPage(ctx, session.ID, leafID, fetch)
+	if err != nil {
+		return Page[models.Message]{}, err
+	}
+
+	result := messagePage(messages, page)
+	replies, err := s.messages.ListReplies(ctx, session.ID, parentIDs(result.Items))
+	if err != nil {
+		return Page[models.Message]{}, err
+	}
+	result.Items, err = s.withReactions(ctx, numberVersions(result.Items, replies))
+	return result, err
+}
+
+// activeLeaf returns the ID of the last message of the session's active
+// branch, which is the newest message if none is set, or "" if the session
+// has no messages
+func (s *ChatService) activeLeaf(ctx context.Context, session models.Session) (string, error) {
+	if session.ActiveMessageID != nil {
+		return *session.ActiveMessageID, nil
+	}
+	newest, err := s.messages.ListPage(ctx, session.ID, "", repository.Page{Limit: 1})
+	if err != nil || len(newest) == 0 {
+		return "", err
+	}
+	return newest[0].ID, nil
+}
+
+// AddReaction adds the user's reaction with the emoji to a message in one of
+// their sessions, unless they already reacted with it. It returns the
+// message with its reactions.
+func (s *ChatService) AddReaction(ctx context.Context, userID, messageID, emoji string) (models.Message, error) {
+	message, err := s.getMessage(ctx, userID, messageID)
+	if err != nil {
+		return message, err
+	}
+
+	reaction := models.Reaction{
+		ID:        uuid.New().String(),
+		MessageID: message.ID,
+		UserID:    userID,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	}
+	if err := s.reactions.Add(ctx, &reaction); err != nil {
+		return message, err
+	}
+
+	message.Reactions, err = s.reactions.ListByMessage(ctx, message.ID)
+	return message, err
+}
+
+// RemoveReaction removes the user's reaction with the emoji from a message in
+// one of their sessions. It returns the message with its remaining reactions.
+func (s *ChatService) RemoveReaction(ctx context.Context, userID, messageID, emoji string) (models.Message, error) {
+	message, err := s.getMessage(ctx, userID, messageID)
+	if err != nil {
+		return message, err
+	}
+
+	if err := s.reactions.Remove(ctx, message.ID, userID, emoji); err != nil {
+		return message, err
+	}
+
+	message.Reactions, err = s.reactions.ListByMessage(ctx, message.ID)
+	return message, err
+}
+
+// StartExchange gets the user's session, or creates one if the ID is empty,
+// and adds the user message to the end of its active branch. The reply is
+// then completed with CompleteReply or FailReply.
+func (s *ChatService) StartExchange(ctx context.Context, userID, sessionID, content string) (Exchange, error) {
+	if sessionID == "" {
+		session := models.Session{
+			ID:         uuid.New().String(),
+			UserID:     userID,
+			Title:      "New Chat",
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+			IsFavorite: false,
+		}
+		return s.startExchange(ctx, session, true, newMessageTree(nil), "", content)
+	}
+
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return Exchange{}, err
+	}
+	messages, err := s.messages.List(ctx, sessionID)
+	if err != nil {
+		return Exchange{}, fmt.Errorf("failed to load conversation history: %w", err)
+	}
+	tree := newMessageTree(messages)
+	return s.startExchange(ctx, session, false, tree, tree.activeLeaf(session), content)
+}
+
+// EditMessage adds a new version of a user message as its sibling, which
+// starts a new active branch and keeps the old one. The reply is then
+// completed with CompleteReply or FailReply.
+func (s *ChatService) EditMessage(ctx context.Context, userID, messageID, content string) (Exchange, error) {
+	message, err := s.getMessage(ctx, userID, messageID)
+	if err != nil {
+		return Exchange{}, err
+	}
+	if message.Sender != "user" {
+		return Exchange{}, ErrNotEditable
+	}
+
+	session, err := s.getSession(ctx, userID, message.SessionID)
+	if err != nil {
+		return Exchange{}, err
+	}
+	messages, err := s.messages.List(ctx, session.ID)
+	if err != nil {
+		return Exchange{}, fmt.Errorf("failed to load conversation history: %w", err)
+	}
+	tree := newMessageTree(messages)
+	return s.startExchange(ctx, session, false, tree, tree.parentID(message), content)
 }
 
-// AddMessage adds a message to a session
-func (s *ChatService) AddMessage(sessionID string, content string, sender string, messageType string) (*models.Message, error) {
-	message := &models.Message{
+// startExchange saves the user message as a reply to the parent, or as a
+// first message if the parent is empty, with a pending reply, and makes the
+// reply the end of the active branch, all in one transaction. A new session
+// is created in the same transaction.
+func (s *ChatService) startExchange(ctx context.Context, session models.Session, newSession bool, tree *messageTree, parentID, content string) (Exchange, error) {
+	exchange := Exchange{Session: session}
+	exchange.UserMessage = models.Message{
 		ID:          uuid.New().String(),
 		Content:     content,
-		Sender:      sender,
+		Sender:      "user",
+		Timestamp:   time.Now(),
+		MessageType: "text",
+		Status:      models.MessageStatusCompleted,
+		SessionID:   session.ID,
+	}
+	if parentID != "" {
+		exchange.UserMessage.ParentID = &parentID
+	}
+	exchange.Reply = models.Message{
+		ID:          uuid.New().String(),
+		Sender:      "bot",
 		Timestamp:   time.Now(),
-		MessageType: messageType,
-		SessionID:   sessionID,
+		MessageType: "text",
+		IsTyping:    true,
+		Status:      models.MessageStatusPending,
+		SessionID:   session.ID,
+		ParentID:    &exchange.UserMessage.ID,
 	}
 
-	if err := s.db.Create(message).Error; err != nil {
-		return nil, err
+	err := s.repos.Transaction(ctx, func(tx repository.Repositories) error {
+		if newSession {
+			if err := tx.Sessions.Create(ctx, &exchange.Session); err != nil {
+				return fmt.Errorf("failed to create session: %w", err)
+			}
+		}
+		if err := tx.Messages.Create(ctx, &exchange.UserMessage); err != nil {
+			return fmt.Errorf("failed to save user message: %w", err)
+		}
+		if err := tx.Messages.Create(ctx, &exchange.Reply); err != nil {
+			return fmt.Errorf("failed to save bot message: %w", err)
+		}
+		if err := tx.Sessions.SetActiveMessage(ctx, session.ID, exchange.Reply.ID); err != nil {
+			return err
+		}
+		return tx.Sessions.Touch(ctx, session.ID, exchange.Reply.Timestamp)
+	})
+	if err != nil {
+		return exchange, err
 	}
 
-	// Update session timestamp
-	if err := s.db.Model(&models.Session{}).Where("id = ?", sessionID).
-		Update("updated_at", time.Now()).Error; err != nil {
-		return nil, err
+	exchange.History = append(tree.path(parentID), exchange.UserMessage)
+	return exchange, nil
+}
+
+// PrepareRetry loads a failed or abandoned bot reply to answer again, along
+// with the history up to the user message it answered, marks it pending and
+// makes its branch active
+func (s *ChatService) PrepareRetry(ctx context.Context, userID, sessionID, messageID string) (Exchange, error) {
+	var exchange Exchange
+
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return exchange, err
+	}
+	exchange.Session = session
+
+	tree, reply, err := s.loadMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return exchange, err
+	}
+	abandoned := reply.Status == models.MessageStatusPending && time.Since(reply.Timestamp) > abandonedAfter
+	if reply.Sender != "bot" || (reply.Status != models.MessageStatusFailed && !abandoned) {
+		return exchange, ErrNotRetryable
+	}
+	userMessage, err := tree.answered(reply)
+	if err != nil {
+		return exchange, err
+	}
+	exchange.UserMessage = userMessage
+	exchange.History = tree.path(userMessage.ID)
+
+	reply.Status = models.MessageStatusPending
+	reply.IsTyping = true
+	err = s.repos.Transaction(ctx, func(tx repository.Repositories) error {
+		if err := tx.Messages.Save(ctx, &reply); err != nil {
+			return err
+		}
+		return tx.Sessions.SetActiveMessage(ctx, sessionID, tree.latestLeaf(reply.ID))
+	})
+	if err != nil {
+		return exchange, err
+	}
+	exchange.Reply = reply
+	return exchange, nil
+}
+
+// Reply asks the AI service to answer the exchange with the requested
+// provider, or the session's if empty. Deltas are passed to onDelta as they
+// arrive when it is not nil. It returns the completion, which may be partial
+// on error, and the provider that answered.
+func (s *ChatService) Reply(ctx context.Context, exchange *Exchange, requested string, onDelta DeltaFunc) (Completion, string, error) {
+	provider, settings := s.providerFor(ctx, requested, exchange.Session)
+	prompt, summarization, err := s.buildContext(ctx, provider, &exchange.Session, exchange.History, settings)
+	if err != nil {
+		return withOverhead(Completion{}, summarization), "", err
+	}
+
+	var completion Completion
+	var answeredBy string
+	if onDelta == nil {
+		completion, answeredBy, err = s.registry.SendMessage(ctx, provider, prompt, settings)
+	} else {
+		completion, answeredBy, err = s.registry.StreamMessage(ctx, provider, prompt, settings, onDelta)
+	}
+	return withOverhead(completion, summarization), answeredBy, err
+}
+
+// CompleteReply records the AI service's answer as the exchange's reply.
+// A reply cancelled by the client is completed with the content received
+// until then.
+func (s *ChatService) CompleteReply(ctx context.Context, exchange *Exchange, completion Completion, provider string, cancelled bool) error {
+	exchange.Reply.Content = completion.Content
+	exchange.Reply.IsCancelled = cancelled
+	return s.finishReply(ctx, exchange, models.MessageStatusCompleted, completion, provider)
+}
+
+// FailReply records that the AI service could not answer the exchange. The
+// reply keeps the given content, such as the partial answer or an apology,
+// and can be answered again after PrepareRetry.
+func (s *ChatService) FailReply(ctx context.Context, exchange *Exchange, content string, completion Completion, provider string) error {
+	exchange.Reply.Content = content
+	return s.finishReply(ctx, exchange, models.MessageStatusFailed, completion, provider)
+}
+
+// finishReply moves the exchange's pending reply to its final status and
+// saves it in one transaction with the session's timestamp
+func (s *ChatService) finishReply(ctx context.Context, exchange *Exchange, status string, completion Completion, provider string) error {
+	reply := &exchange.Reply
+	reply.Status = status
+	reply.IsTyping = false
+	reply.Provider = provider
+	SetUsage(reply, completion)
+
+	return s.repos.Transaction(ctx, func(tx repository.Repositories) error {
+		if err := tx.Messages.Save(ctx, reply); err != nil {
+			return err
+		}
+		return tx.Sessions.Touch(ctx, reply.SessionID, time.Now())
+	})
+}
+
+// CreateReply saves a new bot message, makes it the end of the active branch
+// and marks its session as updated in one transaction. A cancelled message is
+// saved without replacing the reply the user had selected.
+func (s *ChatService) CreateReply(ctx context.Context, message *models.Message) error {
+	return s.repos.Transaction(ctx, func(tx repository.Repositories) error {
+		if err := tx.Messages.Create(ctx, message); err != nil {
+			return err
+		}
+		if message.IsCancelled {
+			return tx.Sessions.Touch(ctx, message.SessionID, time.Now())
+		}
+		if err := tx.Sessions.SetActiveMessage(ctx, message.SessionID, message.ID); err != nil {
+			return err
+		}
+		return tx.Sessions.Touch(ctx, message.SessionID, time.Now())
+	})
+}
+
+// PrepareRegeneration loads the bot message to regenerate along with the
+// history up to the user message it answered
+func (s *ChatService) PrepareRegeneration(ctx context.Context, userID, sessionID, messageID string) (Regeneration, error) {
+	var regeneration Regeneration
+
+	session, err := s.getSession(ctx, userID, sessionID)
+	if err != nil {
+		return regeneration, err
+	}
+	regeneration.Session = session
+
+	tree, original, err := s.loadMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return regeneration, err
+	}
+	userMessage, err := tree.answered(original)
+	if err != nil {
+		return regeneration, err
+	}
+	regeneration.Original = original
+	regeneration.History = tree.path(userMessage.ID)
+	regeneration.Versions = len(tree.versions(original))
+	return regeneration, nil
+}
+
+// Regenerate asks the requested provider, or the session's if empty, for a
+// new version of the reply. The new version is a sibling of the reply that
+// links to the first version; it is saved with CreateReply, which makes it
+// part of the active branch unless it was cancelled. A version cancelled by the client is returned
+// along with the error, with the content received until then.
+func (s *ChatService) Regenerate(ctx context.Context, regeneration *Regeneration, requested string) (models.Message, Completion, error) {
+	original := regeneration.Original
+	firstVersion := original.OriginalMessageID
+	if firstVersion == "" {
+		firstVersion = original.ID
+	}
+
+	provider, settings := s.providerFor(ctx, requested, regeneration.Session)
+	prompt, summarization, err := s.buildContext(ctx, provider, &regeneration.Session, regeneration.History, settings)
+	if err != nil {
+		return models.Message{}, withOverhead(Completion{}, summarization), err
+	}
+	completion, answeredBy, err := s.registry.RegenerateMessage(ctx, provider, prompt, settings)
+	completion = withOverhead(completion, summarization)
+	if err != nil && ctx.Err() == nil {
+		return models.Message{}, completion, err
+	}
+
+	message := models.Message{
+		ID:                uuid.New().String(),
+		Content:           completion.Content,
+		Sender:            "bot",
+		Timestamp:         time.Now(),
+		MessageType:       "text",
+		SessionID:         original.SessionID,
+		IsRegenerated:     true,
+		OriginalMessageID: firstVersion,
+		Provider:          answeredBy,
+		Status:            models.MessageStatusCompleted,
+		ParentID:          original.ParentID,
+		Version:           regeneration.Versions + 1,
+		VersionCount:      regeneration.Versions + 1,
+		IsCancelled:       err != nil,
+	}
+	SetUsage(&message, completion)
+	return message, completion, err
+}
+
+// CheckSession returns ErrSessionNotFound unless the session belongs to the user
+func (s *ChatService) CheckSession(ctx context.Context, userID, sessionID string) error {
+	_, err := s.getSession(ctx, userID, sessionID)
+	return err
+}
+
+// getSession returns the user's session or ErrSessionNotFound
+func (s *ChatService) getSession(ctx context.Context, userID, sessionID string) (models.Session, error) {
+	session, err := s.sessions.Get(ctx, userID, sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return session, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// getMessage returns a message in one of the user's sessions or
+// ErrMessageNotFound
+func (s *ChatService) getMessage(ctx context.Context, userID, messageID string) (models.Message, error) {
+	message, err := s.messages.Find(ctx, messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return message, ErrMessageNotFound
+	}
+	if err != nil {
+		return message, err
 	}
 
+	if err := s.CheckSession(ctx, userID, message.SessionID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return models.Message{}, ErrMessageNotFound
+		}
+		return models.Message{}, err
+	}
 	return message, nil
 }
 
-// GetMessages retrieves messages for a session
-func (s *ChatService) GetMessages(sessionID string) ([]models.Message, error) {
-	var messages []models.Message
-	if err := s.db.Where("session_id = ?", sessionID).
-		Order("timestamp ASC").Find(&messages).Error; err != nil {
-		return nil, err
+// loadMessage loads the tree of a session's messages with the message with
+// the ID
+func (s *ChatService) loadMessage(ctx context.Context, sessionID, messageID string) (*messageTree, models.Message, error) {
+	messages, err := s.messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, models.Message{}, fmt.Errorf("failed to load conversation history: %w", err)
 	}
-	return messages, nil
+
+	tree := newMessageTree(messages)
+	message, ok := tree.byID[messageID]
+	if !ok {
+		return nil, models.Message{}, ErrMessageNotFound
+	}
+	return tree, message, nil
 }
 
-// ToggleFavorite toggles the favorite status of a session
-func (s *ChatService) ToggleFavorite(sessionID string) (*models.Session, error) {
-	var session models.Session
-	if err := s.db.First(&session, "id = ?", sessionID).Error; err != nil {
+// withReactions returns the messages with their reactions, which the message
+// tree is loaded without
+func (s *ChatService) withReactions(ctx context.Context, messages []models.Message) ([]models.Message, error) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	reactions, err := s.reactions.ListByMessages(ctx, ids)
+	if err != nil {
 		return nil, err
 	}
+	byMessage := make(map[string][]models.Reaction)
+	for _, reaction := range reactions {
+		byMessage[reaction.MessageID] = append(byMessage[reaction.MessageID], reaction)
+	}
 
-	session.IsFavorite = !session.IsFavorite
-	session.UpdatedAt = time.Now()
+	result := make([]models.Message, len(messages))
+	for i, message := range messages {
+		message.Reactions = append(make([]models.Reaction, 0), byMessage[message.ID]...)
+		result[i] = message
+	}
+	return result, nil
+}
 
-	if err := s.db.Save(&session).Error; err != nil {
-		return nil, err
+// loadPersona loads the session's persona, leaving it unset if it is missing
+func (s *ChatService) loadPersona(ctx context.Context, session *models.Session) {
+	if session.PersonaID == nil || session.Persona != nil {
+		return
+	}
+	persona, err := s.personas.Get(ctx, *session.PersonaID)
+	if err != nil {
+		log.Printf("Session %s: failed to load persona: %v", session.ID, err)
+		return
+	}
+	session.Persona = &persona
+}
+
+// validateSettings loads the session's persona and checks the resulting
+// generation settings
+func (s *ChatService) validateSettings(ctx context.Context, session *models.Session) error {
+	session.Persona = nil
+	if session.PersonaID != nil {
+		persona, err := s.personas.Get(ctx, *session.PersonaID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrPersonaNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// Other users' personas are reported as missing
+		if persona.UserID != nil && *persona.UserID != session.UserID {
+			return ErrPersonaNotFound
+		}
+		session.Persona = &persona
 	}
 
-	return &session, nil
+	providerName, settings := ResolveSettings(*session)
+	if err := s.registry.ValidateSettings(providerName, settings); err != nil {
+		return &SettingsError{Err: err}
+	}
+	return nil
 }
 
-// GetFavoriteSessions retrieves only favorite sessions
-func (s *ChatService) GetFavoriteSessions() ([]models.Session, error) {
-	var sessions []models.Session
-	if err := s.db.Where("is_favorite = ?", true).
-		Order("updated_at DESC").Find(&sessions).Error; err != nil {
-		return nil, err
+// providerFor returns the provider name and generation settings for an exchange.
+// The provider requested by the client wins over the session's provider,
+// which wins over its persona's and then the default. The configured model
+// only applies to its own provider.
+func (s *ChatService) providerFor(ctx context.Context, requested string, session models.Session) (string, GenerationSettings) {
+	s.loadPersona(ctx, &session)
+
+	providerName, settings := ResolveSettings(session)
+	if requested != "" && requested != providerName {
+		providerName = requested
+		settings.Model = ""
+	}
+
+	if providerName == "" {
+		providerName = s.registry.DefaultName()
+	}
+	if !s.registry.Has(providerName) {
+		log.Printf("Session %s: unknown AI provider %q, using the default provider", session.ID, providerName)
+		settings.Model = ""
+		return s.registry.DefaultName(), settings
 	}
-	return sessions, nil
+	return providerName, settings
 }
 
-// SearchSessions searches sessions by title
-func (s *ChatService) SearchSessions(query string) ([]models.Session, error) {
-	var sessions []models.Session
-	if err := s.db.Where("title ILIKE ?", "%"+query+"%").
-		Order("updated_at DESC").Find(&sessions).Error; err != nil {
-		return nil, err
+// buildContext builds the AI history within the token budget and saves the
+// session summary when older turns were folded into it. The provider and its
+// fallbacks summarize them; the priced completion is returned, if any.
+func (s *ChatService) buildContext(ctx context.Context, provider string, session *models.Session, history []models.Message, settings GenerationSettings) ([]Message, Completion, error) {
+	// The summary may have been built on another branch; it only applies if
+	// its last message is on this one
+	if session.SummarizedUntil != nil && !summarizedOn(history, *session.SummarizedUntil) {
+		session.Summary = ""
+		session.SummarizedUntil = nil
+	}
+
+	prompt, updated, summarization, err := s.contextBuilder.Build(ctx, s.registry.Service(provider), session, history, settings.SystemPrompt)
+	if err != nil {
+		return nil, Completion{}, err
+	}
+
+	if updated {
+		if err := s.sessions.SaveSummary(ctx, session); err != nil {
+			return nil, summarization, err
+		}
+	}
+	return prompt, summarization, nil
+}
+
+// withOverhead adds the usage and cost of the summarization that prepared the
+// prompt to the completion's overhead
+func withOverhead(completion, summarization Completion) Completion {
+	completion.OverheadUsage.PromptTokens += summarization.Usage.PromptTokens
+	completion.OverheadUsage.CompletionTokens += summarization.Usage.CompletionTokens
+	completion.OverheadCost += summarization.Cost
+	return completion
+}
+
+// summarizedOn reports whether the message a summary ends with, identified
+// by its timestamp, is in the history
+func summarizedOn(history []models.Message, until time.Time) bool {
+	for _, message := range history {
+		if message.Timestamp.Equal(until) {
+			return true
+		}
 	}
-	return sessions, nil
+	return false
 }
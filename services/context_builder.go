@@ -0,0 +1,205 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// messageTokenOverhead approximates the per-message tokens the chat format adds
+const messageTokenOverhead = 4
+
+// summaryInstruction asks the AI service to fold old turns into the running summary
+const summaryInstruction = "Summarize the conversation below in a few sentences. " +
+	"Keep names, facts, decisions and open questions the assistant will need later. " +
+	"Reply with the summary only."
+
+// CountTokens estimates the number of tokens in a text.
+// It uses the common four-characters-per-token approximation, which is close
+// enough for budgeting without pulling in a model specific tokenizer.
+func CountTokens(text string) int {
+	runes := len([]rune(text))
+	if runes == 0 {
+		return 0
+	}
+	return (runes + 3) / 4
+}
+
+// CountMessageTokens estimates the tokens a message uses in a request
+func CountMessageTokens(msg Message) int {
+	return CountTokens(msg.Content) + messageTokenOverhead
+}
+
+// ContextBuilder assembles the history sent to the AI service within a token budget.
+// Older turns that do not fit are folded into a rolling summary kept on the session.
+type ContextBuilder struct {
+	// Budget is the number of prompt tokens available for the system prompt,
+	// the summary and the conversation turns.
+	Budget int
+	// SummaryBudget caps the number of tokens the summary may use.
+	SummaryBudget int
+	// SystemPrompt is reserved against the budget unless the caller passes the
+	// session's own prompt; the AI service adds it to the request itself.
+	SystemPrompt string
+}
+
+// NewContextBuilder creates a new context builder
+func NewContextBuilder(budget int) *ContextBuilder {
+	return &ContextBuilder{
+		Budget:        budget,
+		SummaryBudget: budget / 4,
+		SystemPrompt:  DefaultSystemPrompt,
+	}
+}
+
+// Build returns the history for the given session messages, which must be in
+// chronological order and end with the message to be answered. The system
+// prompt is the one the request will use, or empty for the default, and ai
+// summarizes the turns that do not fit.
+// When older turns are dropped, the session's Summary and SummarizedUntil are
+// updated and the returned flag is true so the caller can persist them. The
+// completion that summarized them is returned so that its tokens can be
+// accounted for; it is zero when nothing was summarized.
+func (b *ContextBuilder) Build(ctx context.Context, ai AIService, session *models.Session, messages []models.Message, systemPrompt string) ([]Message, bool, Completion, error) {
+	if len(messages) == 0 {
+		return nil, false, Completion{}, nil
+	}
+	if systemPrompt == "" {
+		systemPrompt = b.SystemPrompt
+	}
+
+	summary := session.Summary
+	summarizedUntil := session.SummarizedUntil
+	canPersist := true
+
+	// A summary that already covers the newest message (e.g. when regenerating
+	// an older answer) cannot be reused, so start from scratch without saving
+	newest := messages[len(messages)-1]
+	if summaryCovers(summarizedUntil, newest.Timestamp) {
+		summary = ""
+		summarizedUntil = nil
+		canPersist = false
+	}
+
+	// Only consider messages the summary does not already cover
+	candidates := make([]models.Message, 0, len(messages))
+	for _, msg := range messages {
+		if summaryCovers(summarizedUntil, msg.Timestamp) {
+			continue
+		}
+		if _, ok := toAPIMessage(msg); ok {
+			candidates = append(candidates, msg)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false, Completion{}, nil
+	}
+
+	available := b.Budget - CountTokens(systemPrompt) - messageTokenOverhead
+	if b.SummaryBudget > 0 {
+		available -= b.SummaryBudget + messageTokenOverhead
+	}
+
+	// Keep the most recent turns that fit in the budget
+	start := len(candidates)
+	used := 0
+	for i := len(candidates) - 1; i >= 0; i-- {
+		apiMessage, _ := toAPIMessage(candidates[i])
+		tokens := CountMessageTokens(apiMessage)
+		if used+tokens > available && start < len(candidates) {
+			break
+		}
+		used += tokens
+		start = i
+	}
+
+	kept := make([]Message, 0, len(candidates)-start)
+	for _, msg := range candidates[start:] {
+		apiMessage, _ := toAPIMessage(msg)
+		kept = append(kept, apiMessage)
+	}
+
+	// The newest message alone may exceed the budget; truncate it to fit
+	if len(kept) == 1 && used > available {
+		kept[0].Content = truncateToTokens(kept[0].Content, available-messageTokenOverhead)
+	}
+
+	updated := false
+	var summarization Completion
+	if dropped := candidates[:start]; len(dropped) > 0 {
+		var err error
+		summarization, err = b.summarize(ctx, ai, summary, BuildHistory(dropped))
+		if err != nil {
+			return nil, false, Completion{}, fmt.Errorf("failed to summarize conversation: %w", err)
+		}
+
+		summary = summarization.Content
+		until := dropped[len(dropped)-1].Timestamp
+		summarizedUntil = &until
+
+		if canPersist {
+			session.Summary = summary
+			session.SummarizedUntil = summarizedUntil
+			updated = true
+		}
+	}
+
+	if summary == "" {
+		return kept, updated, summarization, nil
+	}
+
+	history := make([]Message, 0, len(kept)+1)
+	history = append(history, Message{
+		Role:    RoleSystem,
+		Content: "Summary of the earlier conversation: " + summary,
+	})
+	return append(history, kept...), updated, summarization, nil
+}
+
+// summarize folds the dropped turns into the previous summary. The
+// completion's content is the new summary.
+func (b *ContextBuilder) summarize(ctx context.Context, ai AIService, previous string, dropped []Message) (Completion, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Earlier summary: ")
+		transcript.WriteString(previous)
+		transcript.WriteString("\n\n")
+	}
+	for _, msg := range dropped {
+		transcript.WriteString(msg.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(msg.Content)
+		transcript.WriteString("\n")
+	}
+
+	// Keep the summarization request itself within the budget
+	prompt := summaryInstruction + "\n\n" + transcript.String()
+	prompt = truncateToTokens(prompt, b.Budget-CountTokens(b.SystemPrompt)-2*messageTokenOverhead)
+
+	completion, err := ai.SendMessage(ctx, []Message{{Role: RoleUser, Content: prompt}}, GenerationSettings{})
+	if err != nil {
+		return completion, err
+	}
+
+	completion.Content = truncateToTokens(strings.TrimSpace(completion.Content), b.SummaryBudget)
+	return completion, nil
+}
+
+// truncateToTokens shortens a text so it fits in the given number of tokens
+func truncateToTokens(text string, tokens int) string {
+	if tokens <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	if len(runes) <= tokens*4 {
+		return text
+	}
+	return string(runes[:tokens*4])
+}
+
+// summaryCovers reports whether a summary timestamp covers the given time
+func summaryCovers(summarizedUntil *time.Time, t time.Time) bool {
+	return summarizedUntil != nil && !t.After(*summarizedUntil)
+}
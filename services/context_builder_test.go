@@ -0,0 +1,203 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingAI is a mock AI service that counts the summaries it is asked for
+type countingAI struct {
+	*MockAIService
+	calls int
+}
+
+func (c *countingAI) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	c.calls++
+	return c.MockAIService.SendMessage(ctx, history, settings)
+}
+
+// conversation returns count alternating user and bot messages, a minute
+// apart, each 40 characters (10 tokens) long
+func conversation(count int) []models.Message {
+	start := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	messages := make([]models.Message, count)
+	for i := range messages {
+		sender := "user"
+		if i%2 == 1 {
+			sender = "bot"
+		}
+		messages[i] = models.Message{
+			ID:        fmt.Sprintf("message-%d", i+1),
+			Content:   fmt.Sprintf("%-40s", fmt.Sprintf("Message %d", i+1)),
+			Sender:    sender,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Status:    models.MessageStatusCompleted,
+		}
+	}
+	return messages
+}
+
+// newTestBuilder returns a context builder that keeps the three newest of the
+// test messages: 80 tokens, less 4 for the system prompt and 24 reserved
+// for the summary, leave room for three messages of 14 tokens
+func newTestBuilder() (*ContextBuilder, *countingAI) {
+	ai := &countingAI{MockAIService: NewMockAIService()}
+	return &ContextBuilder{Budget: 80, SummaryBudget: 20}, ai
+}
+
+func TestContextBuilderWithinBudget(t *testing.T) {
+	builder, ai := newTestBuilder()
+	session := &models.Session{}
+
+	history, updated, summarization, err := builder.Build(context.Background(), ai, session, conversation(3), "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(history) != 3 || history[0].Role != RoleUser || history[1].Role != RoleAssistant {
+		t.Errorf("history = %+v, want the three messages", history)
+	}
+	if updated || ai.calls != 0 || summarization.Usage.TotalTokens() != 0 || session.Summary != "" {
+		t.Errorf("summarized a conversation that fits: updated %v, %d calls, %+v", updated, ai.calls, summarization)
+	}
+}
+
+func TestContextBuilderSummarizesDroppedTurns(t *testing.T) {
+	ctx := context.Background()
+	builder, ai := newTestBuilder()
+	session := &models.Session{}
+	messages := conversation(6)
+
+	history, updated, summarization, err := builder.Build(ctx, ai, session, messages, "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if ai.calls != 1 || !updated {
+		t.Fatalf("%d summaries, updated %v; want the dropped turns summarized once", ai.calls, updated)
+	}
+	if len(history) != 4 || history[0].Role != RoleSystem || !strings.Contains(history[0].Content, session.Summary) {
+		t.Fatalf("history = %+v, want the summary and the three newest messages", history)
+	}
+	if history[1].Content != messages[3].Content || history[3].Content != messages[5].Content {
+		t.Errorf("history kept %q to %q, want message 4 to 6", history[1].Content, history[3].Content)
+	}
+	if session.SummarizedUntil == nil || !session.SummarizedUntil.Equal(messages[2].Timestamp) {
+		t.Errorf("summarized until %v, want the time of message 3", session.SummarizedUntil)
+	}
+	if CountTokens(session.Summary) > builder.SummaryBudget {
+		t.Errorf("summary of %d tokens exceeds its budget of %d", CountTokens(session.Summary), builder.SummaryBudget)
+	}
+	if summarization.Usage.PromptTokens == 0 || summarization.Usage.CompletionTokens == 0 {
+		t.Errorf("summarization usage = %+v, want the tokens of the summary request", summarization.Usage)
+	}
+
+	// Building again reuses the summary while the newer turns fit
+	history, updated, _, err = builder.Build(ctx, ai, session, messages, "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if ai.calls != 1 || updated || len(history) != 4 || history[0].Role != RoleSystem {
+		t.Fatalf("%d summaries, updated %v, history of %d; want the summary reused", ai.calls, updated, len(history))
+	}
+
+	// A new turn folds the oldest kept one into the summary
+	history, updated, summarization, err = builder.Build(ctx, ai, session, conversation(7), "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if ai.calls != 2 || !updated || !session.SummarizedUntil.Equal(messages[3].Timestamp) {
+		t.Fatalf("%d summaries, updated %v, summarized until %v; want message 4 folded in",
+			ai.calls, updated, session.SummarizedUntil)
+	}
+	if len(history) != 4 || summarization.Usage.TotalTokens() == 0 {
+		t.Errorf("history of %d messages, summarization %+v", len(history), summarization.Usage)
+	}
+}
+
+func TestContextBuilderRegeneratingOlderAnswer(t *testing.T) {
+	ctx := context.Background()
+	builder, ai := newTestBuilder()
+	messages := conversation(8)
+
+	// The summary covers message 4, which is regenerated from message 3
+	until := messages[3].Timestamp
+	session := &models.Session{Summary: "Earlier summary", SummarizedUntil: &until}
+
+	history, updated, _, err := builder.Build(ctx, ai, session, messages[:3], "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if updated || session.Summary != "Earlier summary" || !session.SummarizedUntil.Equal(until) {
+		t.Errorf("updated %v with summary %q; want the stored summary left alone", updated, session.Summary)
+	}
+	if ai.calls != 0 || len(history) != 3 || history[0].Role != RoleUser {
+		t.Errorf("%d summaries, history %+v; want the three messages without a summary", ai.calls, history)
+	}
+}
+
+func TestContextBuilderTruncatesLongMessage(t *testing.T) {
+	builder, ai := newTestBuilder()
+	messages := []models.Message{{
+		Content:   strings.Repeat("a", 1000),
+		Sender:    "user",
+		Timestamp: time.Now(),
+		Status:    models.MessageStatusCompleted,
+	}}
+
+	history, _, _, err := builder.Build(context.Background(), ai, &models.Session{}, messages, "")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(history) != 1 || ai.calls != 0 {
+		t.Fatalf("history of %d messages after %d summaries, want the message alone", len(history), ai.calls)
+	}
+	if tokens := CountMessageTokens(history[0]); tokens > 52 {
+		t.Errorf("message of %d tokens, want it cut to 52", tokens)
+	}
+}
+
+// failingAI is a mock AI service whose provider is down
+type failingAI struct {
+	*MockAIService
+}
+
+func (failingAI) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return Completion{}, errors.New("provider is down")
+}
+
+func TestReplySummarizesWithSessionProvider(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemoryStore().Repositories()
+	defaultAI, backup := &countingAI{MockAIService: NewMockAIService()}, &countingAI{MockAIService: NewMockAIService()}
+	registry := NewRegistry()
+	registry.Register("default", ProviderMock, nil, defaultAI)
+	registry.Register("primary", ProviderMock, nil, failingAI{NewMockAIService()})
+	registry.Register("backup", ProviderMock, nil, backup)
+	if err := registry.SetFallbacks([]string{"backup"}); err != nil {
+		t.Fatalf("SetFallbacks: %v", err)
+	}
+	chat := NewChatService(repos, registry, &ContextBuilder{Budget: 80, SummaryBudget: 20})
+
+	session := models.Session{ID: "session-1", UserID: "alice", Provider: "primary"}
+	if err := repos.Sessions.Create(ctx, &session); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	// The session's provider is down, so its fallback summarizes and answers
+	completion, answeredBy, err := chat.Reply(ctx, &Exchange{Session: session, History: conversation(7)}, "", nil)
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if answeredBy != "backup" || backup.calls != 2 || defaultAI.calls != 0 {
+		t.Errorf("answered by %s after %d backup and %d default calls, want the summary and the reply from backup",
+			answeredBy, backup.calls, defaultAI.calls)
+	}
+	if completion.OverheadUsage.TotalTokens() == 0 {
+		t.Errorf("overhead = %+v, want the tokens of the summary", completion.OverheadUsage)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrNoProvider is returned when no provider in the fallback chain answered
+var ErrNoProvider = errors.New("no AI provider available")
+
+// ProviderHealth describes a registered provider and its circuit breaker
+type ProviderHealth struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Breaker BreakerStatus `json:"breaker"`
+}
+
+// SetFallbacks sets the providers tried, in order, when a provider fails
+func (r *Registry) SetFallbacks(names []string) error {
+	for _, name := range names {
+		if !r.Has(name) {
+			return fmt.Errorf("unknown fallback AI provider %q", name)
+		}
+	}
+	r.fallbacks = names
+	return nil
+}
+
+// Chain returns the providers tried for a request: the named provider, or the
+// default if the name is empty, followed by the fallbacks
+func (r *Registry) Chain(name string) []string {
+	if name == "" {
+		name = r.defaultName
+	}
+	chain := []string{name}
+	for _, fallback := range r.fallbacks {
+		if fallback != name {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// SendMessage sends the conversation to the named provider, falling back to
+// the next provider in the chain on failure. It returns the completion, priced
+// from the registry's price table, and the name of the provider that answered.
+func (r *Registry) SendMessage(ctx context.Context, name string, history []Message, settings GenerationSettings) (Completion, string, error) {
+	return r.fallback(ctx, name, settings, func(service AIService, settings GenerationSettings) (Completion, error) {
+		return service.SendMessage(ctx, history, settings)
+	}, nil)
+}
+
+// RegenerateMessage regenerates the response with the named provider, falling
+// back to the next provider in the chain on failure
+func (r *Registry) RegenerateMessage(ctx context.Context, name string, history []Message, settings GenerationSettings) (Completion, string, error) {
+	return r.fallback(ctx, name, settings, func(service AIService, settings GenerationSettings) (Completion, error) {
+		return service.RegenerateMessage(ctx, history, settings)
+	}, nil)
+}
+
+// StreamMessage streams the response from the named provider. The next
+// provider in the chain is only tried if the failed one streamed nothing, so
+// the client never receives two answers mixed together.
+func (r *Registry) StreamMessage(ctx context.Context, name string, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, string, error) {
+	delivered := false
+	return r.fallback(ctx, name, settings, func(service AIService, settings GenerationSettings) (Completion, error) {
+		return StreamMessage(ctx, service, history, settings, func(delta string) error {
+			delivered = true
+			return onDelta(delta)
+		})
+	}, func(error) bool {
+		return !delivered
+	})
+}
+
+// Service returns an AI service that sends its requests to the named
+// provider, or the default if the name is empty, and falls back along the
+// chain like SendMessage. Its completions are priced.
+func (r *Registry) Service(name string) AIService {
+	return chainService{registry: r, name: name}
+}
+
+// chainService is an AI service backed by a provider's fallback chain
+type chainService struct {
+	registry *Registry
+	name     string
+}
+
+// SendMessage sends the conversation along the chain
+func (s chainService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	completion, _, err := s.registry.SendMessage(ctx, s.name, history, settings)
+	return completion, err
+}
+
+// RegenerateMessage regenerates the response along the chain
+func (s chainService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	completion, _, err := s.registry.RegenerateMessage(ctx, s.name, history, settings)
+	return completion, err
+}
+
+// Cost returns the cost in USD of a completion by the model
+func (r *Registry) Cost(model string, usage Usage) float64 {
+	return r.prices.Cost(model, usage)
+}
+
+// Health returns the circuit breaker state of every provider sorted by name
+func (r *Registry) Health() []ProviderHealth {
+	health := make([]ProviderHealth, 0, len(r.providers))
+	for _, info := range r.List() {
+		health = append(health, ProviderHealth{
+			Name:    info.Name,
+			Type:    info.Type,
+			Breaker: r.providers[info.Name].breaker.Status(),
+		})
+	}
+	return health
+}
+
+// fallback calls the providers in the chain until one answers or the context
+// is done. The model setting only applies to the requested provider.
+// canFallBack, if set, decides whether a failure may be retried on the next
+// provider.
+func (r *Registry) fallback(ctx context.Context, name string, settings GenerationSettings, call func(AIService, GenerationSettings) (Completion, error), canFallBack func(error) bool) (Completion, string, error) {
+	lastErr := ErrNoProvider
+	for i, providerName := range r.Chain(name) {
+		p, ok := r.providers[providerName]
+		if !ok {
+			continue
+		}
+
+		providerSettings := settings
+		if i > 0 {
+			providerSettings.Model = ""
+		}
+
+		// Partial completions are priced too, since they were paid for
+		completion, err := call(p.guarded, providerSettings)
+		completion.Cost = r.Cost(completion.Model, completion.Usage)
+		if err == nil {
+			return completion, providerName, nil
+		}
+		if ctx.Err() != nil || (canFallBack != nil && !canFallBack(err)) {
+			return completion, providerName, err
+		}
+
+		log.Printf("AI provider %s failed: %v", providerName, err)
+		lastErr = err
+	}
+	return Completion{}, "", lastErr
+}
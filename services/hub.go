@@ -0,0 +1,116 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"log"
+	"sync"
+)
+
+// Event types pushed to the clients of a session
+const (
+	EventMessage     = "message"     // a user or bot message was saved
+	EventTyping      = "typing"      // the bot started or stopped typing
+	EventDelta       = "delta"       // a piece of a streamed bot message
+	EventRegenerated = "regenerated" // a bot message was regenerated
+	EventReaction    = "reaction"    // the reactions of a message changed
+	EventError       = "error"       // a request from the client failed
+	EventQuota       = "quota"       // the user passed the warning threshold of a quota
+)
+
+// subscriberBuffer is the number of events queued for a slow subscriber
+const subscriberBuffer = 64
+
+// Event represents a realtime update for a session
+type Event struct {
+	Type      string      `json:"type"`
+	SessionID string      `json:"sessionId"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// TypingEvent is the payload of a typing event
+type TypingEvent struct {
+	MessageID string `json:"messageId,omitempty"`
+	Sender    string `json:"sender"` // "user" | "bot"
+	IsTyping  bool   `json:"isTyping"`
+}
+
+// DeltaEvent is the payload of a delta event
+type DeltaEvent struct {
+	MessageID string `json:"messageId"`
+	Content   string `json:"content"`
+}
+
+// ReactionEvent is the payload of a reaction event
+type ReactionEvent struct {
+	MessageID string                 `json:"messageId"`
+	Reactions []models.ReactionCount `json:"reactions"`
+}
+
+// Hub fans out session events to every subscriber of the same session
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+// NewHub creates a new hub
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber for a session. The returned function
+// unsubscribes and closes the channel.
+func (h *Hub) Subscribe(sessionID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[sessionID] == nil {
+		h.subscribers[sessionID] = make(map[chan Event]struct{})
+	}
+	h.subscribers[sessionID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[sessionID], ch)
+			if len(h.subscribers[sessionID]) == 0 {
+				delete(h.subscribers, sessionID)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish sends an event to every subscriber of a session.
+// Events are dropped for subscribers whose buffer is full so that a slow
+// client cannot block the others.
+func (h *Hub) Publish(sessionID string, event Event) {
+	if h == nil {
+		return
+	}
+	event.SessionID = sessionID
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subscribers[sessionID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping %s event for slow subscriber of session %s", event.Type, sessionID)
+		}
+	}
+}
+
+// SubscriberCount returns the number of subscribers of a session
+func (h *Hub) SubscriberCount(sessionID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[sessionID])
+}
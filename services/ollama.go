@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Defaults for a local Ollama server
+const (
+	DefaultOllamaURL   = "http://localhost:11434/api/chat"
+	DefaultOllamaModel = "llama3"
+)
+
+// OllamaRequest represents the request structure for the Ollama chat API
+type OllamaRequest struct {
+	Model    string        `json:"model"`
+	Messages []Message     `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  OllamaOptions `json:"options,omitempty"`
+}
+
+// OllamaOptions represents the generation options of an Ollama request
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaResponse represents a response, or a streamed chunk, from the Ollama chat API
+type OllamaResponse struct {
+	Model   string  `json:"model"`
+	Message Message `json:"message"`
+	Done    bool    `json:"done"`
+	Error   string  `json:"error,omitempty"`
+
+	// Token counts, sent with the final response
+	PromptEvalCount int `json:"prompt_eval_count,omitempty"`
+	EvalCount       int `json:"eval_count,omitempty"`
+}
+
+// usage returns the tokens used by the response
+func (r OllamaResponse) usage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+// OllamaService implements the AIService interface using a local Ollama server
+type OllamaService struct {
+	APIURL string
+	Model  string
+	Client *http.Client
+	// StreamClient is used for streamed responses, which Client's total
+	// timeout would cut off. Client is used if it is nil.
+	StreamClient *http.Client
+	Retry        RetryPolicy
+}
+
+// NewOllamaService creates a new Ollama service instance
+func NewOllamaService(apiURL, model string) *OllamaService {
+	if apiURL == "" {
+		apiURL = DefaultOllamaURL
+	}
+	if model == "" {
+		model = DefaultOllamaModel
+	}
+	return &OllamaService{
+		APIURL: apiURL,
+		Model:  model,
+		Client: &http.Client{
+			// Local models can be slow to load on the first request
+			Timeout: 120 * time.Second,
+		},
+		StreamClient: newStreamClient(120 * time.Second),
+		Retry:        DefaultRetryPolicy(),
+	}
+}
+
+// SendMessage sends the conversation to the Ollama server and returns the response
+func (s *OllamaService) SendMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature))
+}
+
+// RegenerateMessage regenerates a response for the last user message in the conversation
+func (s *OllamaService) RegenerateMessage(ctx context.Context, history []Message, settings GenerationSettings) (Completion, error) {
+	return s.makeRequest(ctx, s.buildRequest(settings.systemPrompt(true), history, settings, RegenerateTemperature))
+}
+
+// StreamMessage streams the response to the conversation from the Ollama server
+func (s *OllamaService) StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	request := s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature)
+	request.Stream = true
+
+	resp, err := s.doRequest(ctx, request)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	// Ollama streams one JSON object per line
+	var content strings.Builder
+	completion := Completion{Model: request.Model}
+	result := func() Completion {
+		completion.Content = content.String()
+		return completion
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return result(), fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return result(), fmt.Errorf("API error: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return result(), err
+			}
+		}
+		if chunk.Done {
+			completion.Model = firstNonEmpty(chunk.Model, completion.Model)
+			completion.Usage = chunk.usage()
+			return result(), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result(), fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return result(), ErrStreamIncomplete
+}
+
+// buildRequest builds an Ollama request for the conversation
+func (s *OllamaService) buildRequest(systemPrompt string, history []Message, settings GenerationSettings, temperature float64) OllamaRequest {
+	return OllamaRequest{
+		Model: settings.model(s.Model),
+		Messages: append([]Message{
+			{Role: RoleSystem, Content: systemPrompt},
+		}, history...),
+		Options: OllamaOptions{
+			Temperature: settings.temperature(temperature),
+			TopP:        settings.TopP,
+			NumPredict:  settings.maxTokens(),
+			Stop:        settings.Stop,
+		},
+	}
+}
+
+// makeRequest makes an HTTP request to the Ollama server
+func (s *OllamaService) makeRequest(ctx context.Context, request OllamaRequest) (Completion, error) {
+	resp, err := s.doRequest(ctx, request)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Completion{}, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response OllamaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Completion{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if response.Message.Content == "" {
+		return Completion{}, fmt.Errorf("no response content received")
+	}
+
+	return Completion{
+		Content: response.Message.Content,
+		Model:   firstNonEmpty(response.Model, request.Model),
+		Usage:   response.usage(),
+	}, nil
+}
+
+// doRequest sends the request to the Ollama server, retrying transient
+// failures, and returns the response if the status is OK. The caller must
+// close the response body.
+func (s *OllamaService) doRequest(ctx context.Context, request OllamaRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := s.Client
+	if request.Stream {
+		client = streamingClient(s.StreamClient, s.Client)
+	}
+	return s.Retry.send(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.APIURL, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}, parseOllamaError)
+}
+
+// parseOllamaError extracts the error from an Ollama error response
+func parseOllamaError(body []byte) (string, string) {
+	var response OllamaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", ""
+	}
+	return "", response.Error
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestOllama returns an Ollama service that sends its requests to the
+// upstream without retrying
+func newTestOllama(upstream *httptest.Server) *OllamaService {
+	service := NewOllamaService(upstream.URL, "")
+	service.Client = upstream.Client()
+	service.Retry = RetryPolicy{MaxAttempts: 1}
+	return service
+}
+
+func TestOllamaSendMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, `{
+		"model": "mistral",
+		"message": {"role": "assistant", "content": "Hello"},
+		"done": true,
+		"prompt_eval_count": 26,
+		"eval_count": 2
+	}`)
+
+	temperature := 0.2
+	settings := GenerationSettings{
+		SystemPrompt: "Be brief",
+		Model:        "mistral",
+		Temperature:  &temperature,
+		MaxTokens:    200,
+		Stop:         []string{"END"},
+	}
+	history := []Message{{Role: RoleUser, Content: "Hi"}}
+
+	completion, err := newTestOllama(upstream).SendMessage(context.Background(), history, settings)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if auth := recorded.header.Get("Authorization"); auth != "" {
+		t.Errorf("sent Authorization %q to a local server", auth)
+	}
+
+	var request OllamaRequest
+	recorded.decode(t, &request)
+	if !strings.Contains(string(recorded.body), `"stream":false`) {
+		t.Errorf("request %s does not turn off streaming, which Ollama defaults to", recorded.body)
+	}
+	if request.Model != "mistral" {
+		t.Errorf("model = %q, want mistral", request.Model)
+	}
+	want := []Message{{Role: RoleSystem, Content: "Be brief"}, {Role: RoleUser, Content: "Hi"}}
+	if fmt.Sprint(request.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %+v, want the system prompt and the history", request.Messages)
+	}
+	options := request.Options
+	if options.Temperature == nil || *options.Temperature != temperature || options.TopP != nil {
+		t.Errorf("temperature %v, top p %v; want %v and none", options.Temperature, options.TopP, temperature)
+	}
+	if options.NumPredict != 200 || fmt.Sprint(options.Stop) != "[END]" {
+		t.Errorf("num predict %d, stop %v; want 200 and [END]", options.NumPredict, options.Stop)
+	}
+
+	if completion.Content != "Hello" || completion.Model != "mistral" {
+		t.Errorf("completion = %q from %q, want Hello from mistral", completion.Content, completion.Model)
+	}
+	if completion.Usage != (Usage{PromptTokens: 26, CompletionTokens: 2}) {
+		t.Errorf("usage = %+v, want the prompt and eval counts", completion.Usage)
+	}
+}
+
+func TestOllamaRegenerateMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, `{"message":{"role":"assistant","content":"Hello"},"done":true}`)
+
+	completion, err := newTestOllama(upstream).RegenerateMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+	if err != nil {
+		t.Fatalf("RegenerateMessage: %v", err)
+	}
+
+	var request OllamaRequest
+	recorded.decode(t, &request)
+	if request.Messages[0].Content != RegenerateSystemPrompt || *request.Options.Temperature != RegenerateTemperature {
+		t.Errorf("system %q at temperature %v, want the regeneration prompt and temperature",
+			request.Messages[0].Content, *request.Options.Temperature)
+	}
+	if request.Options.NumPredict != DefaultMaxTokens || request.Model != DefaultOllamaModel {
+		t.Errorf("num predict %d for %q, want the defaults", request.Options.NumPredict, request.Model)
+	}
+	if completion.Model != DefaultOllamaModel || completion.Usage.TotalTokens() != 0 {
+		t.Errorf("completion from %q with usage %+v, want the requested model and no usage", completion.Model, completion.Usage)
+	}
+}
+
+func TestOllamaSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int // status of the provider error, 0 for other errors
+		wantErr    string
+	}{
+		{
+			name:       "unknown model",
+			status:     http.StatusNotFound,
+			body:       `{"error":"model \"llama3\" not found, try pulling it first"}`,
+			wantStatus: http.StatusNotFound,
+			wantErr:    `model "llama3" not found`,
+		},
+		{
+			name:    "empty message",
+			status:  http.StatusOK,
+			body:    `{"message":{"role":"assistant","content":""},"done":true}`,
+			wantErr: "no response content received",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := newRecordingUpstream(t, tt.status, tt.body)
+
+			_, err := newTestOllama(upstream).SendMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("SendMessage error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantStatus != 0 {
+				if providerErr, ok := AsProviderError(err); !ok || providerErr.StatusCode != tt.wantStatus {
+					t.Errorf("SendMessage error = %#v, want a provider error with status %d", err, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestOllamaStreamMessage(t *testing.T) {
+	upstream, recorded := newRecordingUpstream(t, http.StatusOK, strings.Join([]string{
+		`{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":false}`,
+		"",
+		`{"model":"llama3","message":{"role":"assistant","content":", world"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":26,"eval_count":3}`,
+		`{"model":"llama3","message":{"role":"assistant","content":" after the end"},"done":false}`,
+	}, "\n"))
+
+	var deltas []string
+	completion, err := newTestOllama(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, collectDeltas(&deltas))
+	if err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+
+	var request OllamaRequest
+	recorded.decode(t, &request)
+	if !request.Stream {
+		t.Error("request did not ask for a stream")
+	}
+	if got := strings.Join(deltas, "|"); got != "Hello|, world" {
+		t.Errorf("deltas = %q, want Hello and , world", got)
+	}
+	if completion.Content != "Hello, world" || completion.Model != "llama3" {
+		t.Errorf("completion = %q from %q", completion.Content, completion.Model)
+	}
+	if completion.Usage != (Usage{PromptTokens: 26, CompletionTokens: 3}) {
+		t.Errorf("usage = %+v, want the counts of the final chunk", completion.Usage)
+	}
+}
+
+func TestOllamaStreamMessageError(t *testing.T) {
+	upstream, _ := newRecordingUpstream(t, http.StatusOK, strings.Join([]string{
+		`{"message":{"role":"assistant","content":"Hello"},"done":false}`,
+		`{"error":"model runner has unexpectedly stopped"}`,
+	}, "\n"))
+
+	var deltas []string
+	completion, err := newTestOllama(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, collectDeltas(&deltas))
+	if err == nil || !strings.Contains(err.Error(), "model runner has unexpectedly stopped") {
+		t.Fatalf("StreamMessage error = %v, want the error chunk", err)
+	}
+	if completion.Content != "Hello" {
+		t.Errorf("partial completion = %q, want the text sent before the error", completion.Content)
+	}
+}
+
+func TestOllamaStreamMessageTruncated(t *testing.T) {
+	upstream, _ := newRecordingUpstream(t, http.StatusOK, `{"message":{"role":"assistant","content":"Hello"},"done":false}`)
+
+	completion, err := newTestOllama(upstream).StreamMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, func(string) error { return nil })
+	if !errors.Is(err, ErrStreamIncomplete) || completion.Content != "Hello" {
+		t.Fatalf("StreamMessage = %q, %v; want the partial text and ErrStreamIncomplete without a done chunk", completion.Content, err)
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/repository"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Page sizes for listing sessions and messages
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned when a page is requested with a cursor the
+// service did not hand out, or with both a before and an after cursor
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks for at most Limit items, the newest ones unless Before or
+// After is set to a cursor from a previous page. Before continues towards
+// older items and After towards newer ones; a limit of zero or less uses
+// DefaultPageSize and larger limits are capped at MaxPageSize.
+type PageRequest struct {
+	Limit  int
+	Before string
+	After  string
+}
+
+// Page is a page of items in list order. NextCursor continues in the same
+// direction, passed as before for the first page or a page requested with
+// before, and as after for a page requested with after; it is empty on the
+// last page.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// page decodes the request into the repository's page
+func (r PageRequest) page() (repository.Page, error) {
+	page := repository.Page{Limit: r.Limit}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+	if r.Before != "" && r.After != "" {
+		return page, ErrInvalidCursor
+	}
+
+	var err error
+	if r.Before != "" {
+		page.Before, err = decodeCursor(r.Before)
+	}
+	if r.After != "" {
+		page.After, err = decodeCursor(r.After)
+	}
+	return page, err
+}
+
+// encodeCursor returns the opaque form of a cursor handed out to clients
+func encodeCursor(cursor repository.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.Time.Format(time.RFC3339Nano) + "," + cursor.ID))
+}
+
+// decodeCursor parses a cursor handed out by encodeCursor
+func decodeCursor(encoded string) (*repository.Cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(decoded), ",")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &repository.Cursor{Time: t, ID: id}, nil
+}
+
+// sessionPage returns the page of sessions from the sessions fetched for it
+// with one more than the limit, which tells whether there are more
+func sessionPage(sessions []models.Session, page repository.Page) Page[models.Session] {
+	result := Page[models.Session]{Items: sessions}
+	if len(sessions) > page.Limit {
+		// Newest first, so the extra session is the last one, or the first
+		// when going towards newer sessions
+		if page.After != nil {
+			result.Items = sessions[1:]
+		} else {
+			result.Items = sessions[:page.Limit]
+		}
+		next := result.Items[len(result.Items)-1]
+		if page.After != nil {
+			next = result.Items[0]
+		}
+		result.NextCursor = encodeCursor(repository.Cursor{Time: next.UpdatedAt, ID: next.ID})
+	}
+	if result.Items == nil {
+		result.Items = make([]models.Session, 0)
+	}
+	return result
+}
+
+// messagePage returns the page of messages from the messages fetched for it
+// with one more than the limit, in chronological order; the page keeps that
+// order
+func messagePage(messages []models.Message, page repository.Page) Page[models.Message] {
+	result := Page[models.Message]{Items: messages}
+	if len(messages) > page.Limit {
+		// The extra message is the oldest one, or the newest when going
+		// towards newer messages
+		if page.After != nil {
+			result.Items = messages[:page.Limit]
+			result.NextCursor = encodeCursor(messageCursor(result.Items[len(result.Items)-1]))
+		} else {
+			result.Items = messages[len(messages)-page.Limit:]
+			result.NextCursor = encodeCursor(messageCursor(result.Items[0]))
+		}
+	}
+	if result.Items == nil {
+		result.Items = make([]models.Message, 0)
+	}
+	return result
+}
+
+// messageCursor returns the position of a message in chronological order
+func messageCursor(message models.Message) repository.Cursor {
+	return repository.Cursor{Time: message.Timestamp, ID: message.ID}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"chatbot_backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestSession returns a chat service on an in-memory store with a session
+// of the user holding a branch of the given number of messages, oldest first
+func newTestSession(t *testing.T, userID string, count int) (*ChatService, models.Session, []models.Message) {
+	t.Helper()
+
+	ctx := context.Background()
+	repos := repository.NewMemoryStore().Repositories()
+	registry := NewRegistry()
+	registry.Register("mock", ProviderMock, nil, NewMockAIService())
+	chat := NewChatService(repos, registry, NewContextBuilder(4000))
+
+	start := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	session := models.Session{ID: "session-1", UserID: userID, Title: "Test", CreatedAt: start, UpdatedAt: start}
+	if err := repos.Sessions.Create(ctx, &session); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	messages := make([]models.Message, count)
+	var parentID *string
+	for i := range messages {
+		sender := "user"
+		if i%2 == 1 {
+			sender = "bot"
+		}
+		messages[i] = models.Message{
+			ID:        fmt.Sprintf("message-%d", i+1),
+			Content:   fmt.Sprintf("Message %d", i+1),
+			Sender:    sender,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Status:    models.MessageStatusCompleted,
+			SessionID: session.ID,
+			ParentID:  parentID,
+		}
+		if err := repos.Messages.Create(ctx, &messages[i]); err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		parentID = &messages[i].ID
+	}
+	if err := repos.Sessions.SetActiveMessage(ctx, session.ID, messages[count-1].ID); err != nil {
+		t.Fatalf("set active message: %v", err)
+	}
+	return chat, session, messages
+}
+
+// messageIDs returns the IDs of the messages
+func messageIDs(messages []models.Message) []string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+func TestGetMessagesPages(t *testing.T) {
+	ctx := context.Background()
+	chat, session, messages := newTestSession(t, "alice", 5)
+	if _, err := chat.AddReaction(ctx, "alice", "message-1", "👍"); err != nil {
+		t.Fatalf("AddReaction: %v", err)
+	}
+	if _, err := chat.AddReaction(ctx, "alice", "message-5", "🎉"); err != nil {
+		t.Fatalf("AddReaction: %v", err)
+	}
+
+	// The first page holds the newest messages in chronological order, with
+	// their reactions
+	page, err := chat.GetMessages(ctx, "alice", session.ID, false, PageRequest{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := fmt.Sprint(messageIDs(page.Items)); got != "[message-4 message-5]" || page.NextCursor == "" {
+		t.Fatalf("first page = %s, cursor %q; want the two newest messages and a cursor", got, page.NextCursor)
+	}
+	if reactions := page.Items[1].Reactions; len(reactions) != 1 || reactions[0].Emoji != "🎉" {
+		t.Errorf("reactions of message-5 = %+v, want 🎉", reactions)
+	}
+	if reactions := page.Items[0].Reactions; reactions == nil || len(reactions) != 0 {
+		t.Errorf("reactions of message-4 = %#v, want none", reactions)
+	}
+
+	older, err := chat.GetMessages(ctx, "alice", session.ID, false, PageRequest{Limit: 2, Before: page.NextCursor})
+	if err != nil {
+		t.Fatalf("GetMessages before: %v", err)
+	}
+	if got := fmt.Sprint(messageIDs(older.Items)); got != "[message-2 message-3]" {
+		t.Fatalf("older page = %s, want message-2 and message-3", got)
+	}
+
+	oldest, err := chat.GetMessages(ctx, "alice", session.ID, false, PageRequest{Limit: 2, Before: older.NextCursor})
+	if err != nil {
+		t.Fatalf("GetMessages before: %v", err)
+	}
+	if got := fmt.Sprint(messageIDs(oldest.Items)); got != "[message-1]" || oldest.NextCursor != "" {
+		t.Fatalf("oldest page = %s, cursor %q; want message-1 and no cursor", got, oldest.NextCursor)
+	}
+	if reactions := oldest.Items[0].Reactions; len(reactions) != 1 || reactions[0].Emoji != "👍" {
+		t.Errorf("reactions of message-1 = %+v, want 👍", reactions)
+	}
+
+	// After continues towards newer messages
+	newer, err := chat.GetMessages(ctx, "alice", session.ID, false, PageRequest{Limit: 3, After: encodeCursor(messageCursor(messages[0]))})
+	if err != nil {
+		t.Fatalf("GetMessages after: %v", err)
+	}
+	if got := fmt.Sprint(messageIDs(newer.Items)); got != "[message-2 message-3 message-4]" || newer.NextCursor == "" {
+		t.Fatalf("newer page = %s, cursor %q; want message-2 to message-4 and a cursor", got, newer.NextCursor)
+	}
+}
+
+func TestGetMessagesInvalidCursor(t *testing.T) {
+	chat, session, _ := newTestSession(t, "alice", 1)
+
+	for name, request := range map[string]PageRequest{
+		"garbage":          {Before: "not a cursor"},
+		"before and after": {Before: encodeCursor(repository.Cursor{Time: time.Now(), ID: "a"}), After: encodeCursor(repository.Cursor{Time: time.Now(), ID: "b"})},
+	} {
+		if _, err := chat.GetMessages(context.Background(), "alice", session.ID, false, request); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: GetMessages = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"chatbot_backend/config"
+	"chatbot_backend/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned when a user may not make another AI request
+var (
+	ErrTokenQuotaExceeded = errors.New("monthly token quota exceeded")
+	ErrCostLimitExceeded  = errors.New("monthly spending limit exceeded")
+)
+
+// ErrUnknownPlan is returned when assigning a plan that is not configured
+var ErrUnknownPlan = errors.New("unknown quota plan")
+
+// QuotaStatus describes a user's limits and usage in the current period.
+// Zero limits are unlimited.
+type QuotaStatus struct {
+	Plan        string    `json:"plan"`
+	TokensUsed  int64     `json:"tokensUsed"`
+	TokenLimit  int64     `json:"tokenLimit"`
+	CostUsed    float64   `json:"costUsed"`
+	CostLimit   float64   `json:"costLimit"`
+	PeriodStart time.Time `json:"periodStart"`
+	ResetsAt    time.Time `json:"resetsAt"`
+	Warning     bool      `json:"warning"` // usage passed the warning threshold of a limit
+}
+
+// Limited reports whether the user has any limit
+func (q QuotaStatus) Limited() bool {
+	return q.TokenLimit > 0 || q.CostLimit > 0
+}
+
+// Err returns the error for an exhausted limit, or nil
+func (q QuotaStatus) Err() error {
+	if q.CostLimit > 0 && q.CostUsed >= q.CostLimit {
+		return ErrCostLimitExceeded
+	}
+	if q.TokenLimit > 0 && q.TokensUsed >= q.TokenLimit {
+		return ErrTokenQuotaExceeded
+	}
+	return nil
+}
+
+// QuotaUpdate changes a user's plan or limit overrides. Nil fields are left
+// unchanged; a negative limit removes the override.
+type QuotaUpdate struct {
+	Plan       *string
+	TokenLimit *int64
+	CostLimit  *float64
+}
+
+// QuotaService enforces the monthly token and spending limits of users.
+// Periods are calendar months in UTC.
+type QuotaService struct {
+	db           *gorm.DB
+	plans        map[string]config.QuotaPlan
+	defaultPlan  string
+	warningRatio float64
+
+	// now returns the current time; tests replace it to move between periods
+	now func() time.Time
+}
+
+// NewQuotaService creates a quota service with the configured plans
+func NewQuotaService(db *gorm.DB, cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		db:           db,
+		plans:        cfg.QuotaPlans,
+		defaultPlan:  cfg.DefaultPlan,
+		warningRatio: float64(cfg.QuotaWarningPercent) / 100,
+		now:          time.Now,
+	}
+}
+
+// HasPlan reports whether the plan is configured
+func (s *QuotaService) HasPlan(plan string) bool {
+	_, ok := s.plans[plan]
+	return ok
+}
+
+// Status returns the user's quota in the current period
+func (s *QuotaService) Status(ctx context.Context, userID string) (QuotaStatus, error) {
+	quota, err := s.load(s.db.WithContext(ctx), userID)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	return s.status(quota), nil
+}
+
+// Check returns the user's quota, with ErrTokenQuotaExceeded or
+// ErrCostLimitExceeded if a limit is exhausted
+func (s *QuotaService) Check(ctx context.Context, userID string) (QuotaStatus, error) {
+	status, err := s.Status(ctx, userID)
+	if err != nil {
+		return status, err
+	}
+	return status, status.Err()
+}
+
+// Record adds the tokens and cost of a completion in the session, including
+// its overhead, to the user's usage, keeps a usage record of them and returns
+// the updated quota
+func (s *QuotaService) Record(ctx context.Context, userID, sessionID string, completion Completion) (QuotaStatus, error) {
+	tokens := completion.Usage.TotalTokens() + completion.OverheadUsage.TotalTokens()
+	cost := completion.Cost + completion.OverheadCost
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.ensure(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserQuota{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"tokens_used": gorm.Expr("tokens_used + ?", tokens),
+			"cost_used":   gorm.Expr("cost_used + ?", cost),
+			"updated_at":  s.now(),
+		}).Error; err != nil {
+			return err
+		}
+
+		if tokens == 0 && cost == 0 {
+			return nil
+		}
+		return tx.Create(&models.UsageRecord{
+			ID:               uuid.New().String(),
+			UserID:           userID,
+			SessionID:        sessionID,
+			Model:            completion.Model,
+			PromptTokens:     completion.Usage.PromptTokens,
+			CompletionTokens: completion.Usage.CompletionTokens,
+			OverheadTokens:   completion.OverheadUsage.TotalTokens(),
+			Cost:             cost,
+			CreatedAt:        s.now().UTC(),
+		}).Error
+	})
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+
+	return s.Status(ctx, userID)
+}
+
+// Update changes the user's plan or limit overrides
+func (s *QuotaService) Update(ctx context.Context, userID string, update QuotaUpdate) (QuotaStatus, error) {
+	db := s.db.WithContext(ctx)
+
+	changes := map[string]interface{}{"updated_at": s.now()}
+	if update.Plan != nil {
+		if *update.Plan != "" && !s.HasPlan(*update.Plan) {
+			return QuotaStatus{}, ErrUnknownPlan
+		}
+		changes["plan"] = *update.Plan
+	}
+	if update.TokenLimit != nil {
+		changes["token_limit"] = *update.TokenLimit
+		if *update.TokenLimit < 0 {
+			changes["token_limit"] = nil
+		}
+	}
+	if update.CostLimit != nil {
+		changes["cost_limit"] = *update.CostLimit
+		if *update.CostLimit < 0 {
+			changes["cost_limit"] = nil
+		}
+	}
+
+	if err := s.ensure(db, userID); err != nil {
+		return QuotaStatus{}, err
+	}
+	if err := db.Model(&models.UserQuota{}).Where("user_id = ?", userID).Updates(changes).Error; err != nil {
+		return QuotaStatus{}, err
+	}
+
+	return s.Status(ctx, userID)
+}
+
+// Reset clears the user's usage in the current period
+func (s *QuotaService) Reset(ctx context.Context, userID string) (QuotaStatus, error) {
+	if err := s.db.WithContext(ctx).Model(&models.UserQuota{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"tokens_used":  0,
+		"cost_used":    0,
+		"period_start": s.periodStart(),
+		"updated_at":   s.now(),
+	}).Error; err != nil {
+		return QuotaStatus{}, err
+	}
+	return s.Status(ctx, userID)
+}
+
+// ResetExpired clears the usage of every quota left from a past period and
+// returns how many were reset
+func (s *QuotaService) ResetExpired(ctx context.Context) (int64, error) {
+	period := s.periodStart()
+	result := s.db.WithContext(ctx).Model(&models.UserQuota{}).Where("period_start < ?", period).Updates(map[string]interface{}{
+		"tokens_used":  0,
+		"cost_used":    0,
+		"period_start": period,
+		"updated_at":   s.now(),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// StartResetSchedule resets expired quotas now and then every interval until
+// the context is done. Quotas of a past period never block requests, so the
+// schedule only keeps the stored usage current.
+func (s *QuotaService) StartResetSchedule(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if reset, err := s.ResetExpired(ctx); err != nil {
+				log.Printf("Failed to reset expired quotas: %v", err)
+			} else if reset > 0 {
+				log.Printf("Reset %d expired quotas", reset)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// load returns the user's quota row, or an empty quota if there is none
+func (s *QuotaService) load(db *gorm.DB, userID string) (models.UserQuota, error) {
+	var quota models.UserQuota
+	if err := db.First(&quota, "user_id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.UserQuota{UserID: userID, PeriodStart: s.periodStart()}, nil
+		}
+		return quota, err
+	}
+	return quota, nil
+}
+
+// ensure creates the user's quota row if needed and rolls it over to the
+// current period
+func (s *QuotaService) ensure(db *gorm.DB, userID string) error {
+	period := s.periodStart()
+	quota := models.UserQuota{UserID: userID, PeriodStart: period, UpdatedAt: s.now()}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&quota).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&models.UserQuota{}).Where("user_id = ? AND period_start < ?", userID, period).Updates(map[string]interface{}{
+		"tokens_used":  0,
+		"cost_used":    0,
+		"period_start": period,
+	}).Error
+}
+
+// status computes the quota status of a row
+func (s *QuotaService) status(quota models.UserQuota) QuotaStatus {
+	plan := quota.Plan
+	if plan == "" {
+		plan = s.defaultPlan
+	}
+	limits := s.plans[plan]
+	if quota.TokenLimit != nil {
+		limits.TokenLimit = *quota.TokenLimit
+	}
+	if quota.CostLimit != nil {
+		limits.CostLimit = *quota.CostLimit
+	}
+
+	period := s.periodStart()
+	status := QuotaStatus{
+		Plan:        plan,
+		TokenLimit:  limits.TokenLimit,
+		CostLimit:   limits.CostLimit,
+		PeriodStart: period,
+		ResetsAt:    period.AddDate(0, 1, 0),
+	}
+
+	// Usage from a past period has not been reset yet but no longer counts
+	if !quota.PeriodStart.Before(period) {
+		status.TokensUsed = quota.TokensUsed
+		status.CostUsed = quota.CostUsed
+	}
+
+	status.Warning = (status.TokenLimit > 0 && float64(status.TokensUsed) >= s.warningRatio*float64(status.TokenLimit)) ||
+		(status.CostLimit > 0 && status.CostUsed >= s.warningRatio*status.CostLimit)
+	return status
+}
+
+// periodStart returns the start of the current quota period
+func (s *QuotaService) periodStart() time.Time {
+	now := s.now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"chatbot_backend/config"
+	"chatbot_backend/migrations"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestQuotas returns a quota service on a fresh SQLite database whose
+// default plan allows 100 tokens and $1 a month
+func newTestQuotas(t *testing.T, clock *fakeClock) *QuotaService {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	quotas := NewQuotaService(db, &config.Config{
+		QuotaPlans: map[string]config.QuotaPlan{
+			"free": {TokenLimit: 100, CostLimit: 1},
+			"pro":  {TokenLimit: 10000},
+		},
+		DefaultPlan:         "free",
+		QuotaWarningPercent: 80,
+	})
+	quotas.now = clock.Now
+	return quotas
+}
+
+// usage returns a completion that used the given tokens and cost
+func usage(tokens int, cost float64) Completion {
+	return Completion{Usage: Usage{PromptTokens: tokens}, Cost: cost}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	ctx := context.Background()
+	quotas := newTestQuotas(t, newFakeClock())
+
+	status, err := quotas.Record(ctx, "user-1", "session-1", usage(80, 0.1))
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if status.TokensUsed != 80 || !status.Warning {
+		t.Errorf("status = %+v, want 80 tokens used and a warning", status)
+	}
+	if _, err := quotas.Check(ctx, "user-1"); err != nil {
+		t.Fatalf("Check under the limit = %v", err)
+	}
+
+	// Summarizing the history is paid for by the user too
+	completion := usage(10, 0)
+	completion.OverheadUsage = Usage{PromptTokens: 10}
+	if _, err := quotas.Record(ctx, "user-1", "session-1", completion); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if _, err := quotas.Check(ctx, "user-1"); !errors.Is(err, ErrTokenQuotaExceeded) {
+		t.Fatalf("Check at the token limit = %v, want ErrTokenQuotaExceeded", err)
+	}
+
+	// A plan with more tokens but no spending limit lets the user go on
+	plan := "pro"
+	if _, err := quotas.Update(ctx, "user-1", QuotaUpdate{Plan: &plan}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := quotas.Check(ctx, "user-1"); err != nil {
+		t.Fatalf("Check on the pro plan = %v", err)
+	}
+
+	// An override of the cost limit applies on top of the plan
+	costLimit := 0.1
+	if _, err := quotas.Update(ctx, "user-1", QuotaUpdate{CostLimit: &costLimit}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := quotas.Check(ctx, "user-1"); !errors.Is(err, ErrCostLimitExceeded) {
+		t.Fatalf("Check over the cost override = %v, want ErrCostLimitExceeded", err)
+	}
+}
+
+func TestQuotaPeriods(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	quotas := newTestQuotas(t, clock)
+
+	if _, err := quotas.Record(ctx, "user-1", "session-1", usage(100, 0)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	status, err := quotas.Check(ctx, "user-1")
+	if !errors.Is(err, ErrTokenQuotaExceeded) {
+		t.Fatalf("Check = %v, want ErrTokenQuotaExceeded", err)
+	}
+	if want := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC); !status.ResetsAt.Equal(want) {
+		t.Errorf("resets at %v, want %v", status.ResetsAt, want)
+	}
+
+	// Usage of a past month no longer counts, even before it is reset
+	clock.Advance(20 * 24 * time.Hour)
+	status, err = quotas.Check(ctx, "user-1")
+	if err != nil || status.TokensUsed != 0 {
+		t.Fatalf("Check in the next month = %+v, %v; want no usage", status, err)
+	}
+
+	reset, err := quotas.ResetExpired(ctx)
+	if err != nil || reset != 1 {
+		t.Fatalf("ResetExpired = %d, %v; want 1 quota reset", reset, err)
+	}
+	if _, err := quotas.Record(ctx, "user-1", "session-1", usage(30, 0)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if status, _ := quotas.Status(ctx, "user-1"); status.TokensUsed != 30 {
+		t.Errorf("tokens used = %d after the reset, want 30", status.TokensUsed)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"chatbot_backend/config"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Provider types supported by the registry
+const (
+	ProviderOpenAI    = "openai"    // OpenAI and compatible servers (llama.cpp, vLLM, ...)
+	ProviderAnthropic = "anthropic" // Anthropic Messages API
+	ProviderOllama    = "ollama"    // local Ollama server
+	ProviderMock      = "mock"      // canned responses for development and tests
+)
+
+// ProviderInfo describes a registered provider without its credentials
+type ProviderInfo struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Models []string `json:"models"`
+}
+
+// provider is a registered AI service guarded by its circuit breaker
+type provider struct {
+	info    ProviderInfo
+	guarded AIService
+	breaker *CircuitBreaker
+}
+
+// Registry holds the configured AI providers by name
+type Registry struct {
+	providers      map[string]provider
+	defaultName    string
+	fallbacks      []string
+	maxTokensLimit int
+
+	breakerThreshold int
+	breakerTimeout   time.Duration
+
+	prices PriceTable
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		providers:        make(map[string]provider),
+		maxTokensLimit:   4096,
+		breakerThreshold: 5,
+		breakerTimeout:   30 * time.Second,
+	}
+}
+
+// NewRegistryFromConfig creates a registry with the configured providers
+func NewRegistryFromConfig(cfg *config.Config) (*Registry, error) {
+	registry := NewRegistry()
+	if cfg.MaxTokensLimit > 0 {
+		registry.maxTokensLimit = cfg.MaxTokensLimit
+	}
+	registry.breakerThreshold = cfg.BreakerFailureThreshold
+	registry.breakerTimeout = cfg.BreakerOpenTimeout
+	registry.prices = cfg.ModelPrices
+
+	retry := RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   cfg.RetryBaseDelay,
+		MaxDelay:    cfg.RetryMaxDelay,
+	}
+	for _, providerConfig := range cfg.Providers {
+		service, err := NewProvider(providerConfig, retry)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(providerConfig.Name, providerConfig.Type, providerConfig.Models, service)
+	}
+
+	if err := registry.SetDefault(cfg.DefaultProvider); err != nil {
+		return nil, err
+	}
+	if err := registry.SetFallbacks(cfg.FallbackProviders); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// NewProvider creates the AI service for a provider configuration
+func NewProvider(providerConfig config.ProviderConfig, retry RetryPolicy) (AIService, error) {
+	model := ""
+	if len(providerConfig.Models) > 0 {
+		model = providerConfig.Models[0]
+	}
+
+	switch providerConfig.Type {
+	case ProviderOpenAI:
+		if model == "" {
+			model = DefaultOpenAIModel
+		}
+		apiURL := providerConfig.URL
+		if apiURL == "" {
+			apiURL = "https://api.openai.com/v1/chat/completions"
+		}
+		return &OpenAIService{
+			APIKey: providerConfig.APIKey,
+			APIURL: apiURL,
+			Model:  model,
+			Client: &http.Client{
+				Timeout: 30 * time.Second,
+			},
+			StreamClient: newStreamClient(30 * time.Second),
+			Retry:        retry,
+		}, nil
+	case ProviderAnthropic:
+		service := NewAnthropicService(providerConfig.URL, providerConfig.APIKey, model)
+		service.Retry = retry
+		return service, nil
+	case ProviderOllama:
+		service := NewOllamaService(providerConfig.URL, model)
+		service.Retry = retry
+		return service, nil
+	case ProviderMock:
+		return NewMockAIService(), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q for AI provider %q", providerConfig.Type, providerConfig.Name)
+	}
+}
+
+// Register adds a provider to the registry behind a circuit breaker.
+// The first provider registered becomes the default.
+func (r *Registry) Register(name, providerType string, models []string, service AIService) {
+	breaker := NewCircuitBreaker(r.breakerThreshold, r.breakerTimeout)
+	r.providers[name] = provider{
+		info: ProviderInfo{
+			Name:   name,
+			Type:   providerType,
+			Models: models,
+		},
+		guarded: &guardedService{AIService: service, breaker: breaker},
+		breaker: breaker,
+	}
+	if r.defaultName == "" {
+		r.defaultName = name
+	}
+}
+
+// SetDefault sets the provider used when none is requested
+func (r *Registry) SetDefault(name string) error {
+	if _, ok := r.providers[name]; !ok {
+		return fmt.Errorf("unknown AI provider %q", name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+// DefaultName returns the name of the default provider
+func (r *Registry) DefaultName() string {
+	return r.defaultName
+}
+
+// Default returns the default provider
+func (r *Registry) Default() AIService {
+	return r.providers[r.defaultName].guarded
+}
+
+// Has reports whether a provider is registered under the name
+func (r *Registry) Has(name string) bool {
+	_, ok := r.providers[name]
+	return ok
+}
+
+// Get returns the provider registered under the name, or the default
+// provider if the name is empty
+func (r *Registry) Get(name string) (AIService, error) {
+	if name == "" {
+		return r.Default(), nil
+	}
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q", name)
+	}
+	return p.guarded, nil
+}
+
+// List returns the registered providers sorted by name
+func (r *Registry) List() []ProviderInfo {
+	list := make([]ProviderInfo, 0, len(r.providers))
+	for _, p := range r.providers {
+		list = append(list, p.info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed provider calls are retried.
+// Delays grow exponentially from BaseDelay up to MaxDelay with random jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// sleep waits between attempts; tests replace it to avoid real delays
+	sleep func(time.Duration)
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    8 * time.Second,
+	}
+}
+
+// ProviderError is returned when an AI provider call fails
+type ProviderError struct {
+	StatusCode int           // HTTP status, 0 for network errors
+	Type       string        // provider error type or code, if any
+	Message    string        // provider error message or response body
+	RetryAfter time.Duration // delay requested by the provider, if any
+	Retryable  bool          // whether the call may succeed if repeated
+	Attempts   int           // number of attempts made
+	Err        error         // underlying network error, if any
+}
+
+// Error implements the error interface
+func (e *ProviderError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed to make request after %d attempt(s): %v", e.Attempts, e.Err)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API request failed with status %d", e.StatusCode)
+}
+
+// Unwrap returns the underlying network error
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// IsContextLengthError reports whether the provider rejected the request
+// because the prompt is too long for the model
+func (e *ProviderError) IsContextLengthError() bool {
+	text := strings.ToLower(e.Type + " " + e.Message)
+	return strings.Contains(text, "context_length") ||
+		strings.Contains(text, "context length") ||
+		strings.Contains(text, "prompt is too long")
+}
+
+// AsProviderError returns the ProviderError in err's chain, if any
+func AsProviderError(err error) (*ProviderError, bool) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr, true
+	}
+	return nil, false
+}
+
+// isRetryableStatus reports whether a status is worth retrying
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529: // Anthropic "overloaded"
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// backoff returns the delay before the given retry (1 for the first retry)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Equal jitter: half fixed, half random, so retries never fire at once
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// errorParser extracts the provider's error type and message from a response body
+type errorParser func(body []byte) (errorType, message string)
+
+// wait pauses for the delay or until the context is done
+func (p RetryPolicy) wait(ctx context.Context, delay time.Duration) error {
+	if p.sleep != nil {
+		p.sleep(delay)
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// send performs an HTTP request with retries and returns the response if the
+// status is OK. newRequest is called for every attempt so the body can be
+// re-sent. Retrying stops as soon as the context is done. Failures are
+// returned as *ProviderError. The caller must close the response body.
+func (p RetryPolicy) send(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error), parseError errorParser) (*http.Response, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var lastErr *ProviderError
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := p.backoff(attempt - 1)
+			if lastErr.RetryAfter > delay {
+				delay = lastErr.RetryAfter
+			}
+			if err := p.wait(ctx, delay); err != nil {
+				return nil, &ProviderError{Attempts: attempt - 1, Err: err}
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, &ProviderError{Attempts: attempt, Err: ctx.Err()}
+			}
+			lastErr = &ProviderError{Retryable: true, Attempts: attempt, Err: err}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		lastErr = &ProviderError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Retryable:  isRetryableStatus(resp.StatusCode),
+			Attempts:   attempt,
+		}
+		if readErr == nil {
+			lastErr.Type, lastErr.Message = parseError(body)
+			if lastErr.Message == "" {
+				lastErr.Message = strings.TrimSpace(string(body))
+			}
+		}
+
+		// A context length error never succeeds on retry, whatever the status
+		if !lastErr.Retryable || lastErr.IsContextLengthError() {
+			lastErr.Retryable = false
+			return nil, lastErr
+		}
+
+		// Do not wait longer than the policy allows
+		if lastErr.RetryAfter > p.MaxDelay {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// okResponse is the body of a successful OpenAI response
+const okResponse = `{"model":"test-model","choices":[{"message":{"role":"assistant","content":"Hello"}}]}`
+
+// scriptedResponse is one response of a scripted upstream
+type scriptedResponse struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+func TestRetryPolicy(t *testing.T) {
+	contextLength := `{"error":{"type":"invalid_request_error","message":"This model's maximum context length is 4097 tokens","code":"context_length_exceeded"}}`
+
+	tests := []struct {
+		name      string
+		responses []scriptedResponse
+		wantErr   int // status of the returned error, 0 for success
+		attempts  int
+		delays    []time.Duration // exact delays, or nil to only count them
+		waits     int
+	}{
+		{
+			name:      "retries rate limits",
+			responses: []scriptedResponse{{status: http.StatusTooManyRequests}, {status: http.StatusOK, body: okResponse}},
+			attempts:  2,
+			waits:     1,
+		},
+		{
+			name: "retries bad gateways and unavailable servers",
+			responses: []scriptedResponse{
+				{status: http.StatusBadGateway},
+				{status: http.StatusServiceUnavailable},
+				{status: http.StatusOK, body: okResponse},
+			},
+			attempts: 3,
+			waits:    2,
+		},
+		{
+			name:      "waits as long as Retry-After asks",
+			responses: []scriptedResponse{{status: http.StatusTooManyRequests, retryAfter: "5"}, {status: http.StatusOK, body: okResponse}},
+			attempts:  2,
+			delays:    []time.Duration{5 * time.Second},
+			waits:     1,
+		},
+		{
+			name:      "gives up when Retry-After exceeds the maximum delay",
+			responses: []scriptedResponse{{status: http.StatusTooManyRequests, retryAfter: "60"}},
+			wantErr:   http.StatusTooManyRequests,
+			attempts:  1,
+		},
+		{
+			name:      "does not retry authentication errors",
+			responses: []scriptedResponse{{status: http.StatusUnauthorized, body: `{"error":{"type":"invalid_api_key","message":"Incorrect API key"}}`}},
+			wantErr:   http.StatusUnauthorized,
+			attempts:  1,
+		},
+		{
+			name:      "does not retry context length errors",
+			responses: []scriptedResponse{{status: http.StatusBadRequest, body: contextLength}},
+			wantErr:   http.StatusBadRequest,
+			attempts:  1,
+		},
+		{
+			name:      "gives up after the maximum attempts",
+			responses: []scriptedResponse{{status: http.StatusServiceUnavailable}},
+			wantErr:   http.StatusServiceUnavailable,
+			attempts:  3,
+			waits:     2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The last response repeats once the script runs out
+				response := tt.responses[min(attempts, len(tt.responses)-1)]
+				attempts++
+				if response.retryAfter != "" {
+					w.Header().Set("Retry-After", response.retryAfter)
+				}
+				w.WriteHeader(response.status)
+				fmt.Fprint(w, response.body)
+			}))
+			defer upstream.Close()
+
+			var delays []time.Duration
+			service := &OpenAIService{
+				APIURL: upstream.URL,
+				Model:  "test-model",
+				Client: upstream.Client(),
+				Retry: RetryPolicy{
+					MaxAttempts: 3,
+					BaseDelay:   100 * time.Millisecond,
+					MaxDelay:    10 * time.Second,
+					sleep:       func(delay time.Duration) { delays = append(delays, delay) },
+				},
+			}
+
+			completion, err := service.SendMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+			if tt.wantErr == 0 {
+				if err != nil || completion.Content != "Hello" {
+					t.Fatalf("SendMessage = %q, %v; want Hello", completion.Content, err)
+				}
+			} else {
+				providerErr, ok := AsProviderError(err)
+				if !ok || providerErr.StatusCode != tt.wantErr {
+					t.Fatalf("SendMessage error = %v, want a provider error with status %d", err, tt.wantErr)
+				}
+				if providerErr.Attempts != tt.attempts {
+					t.Errorf("error reports %d attempts, want %d", providerErr.Attempts, tt.attempts)
+				}
+			}
+
+			if attempts != tt.attempts {
+				t.Errorf("upstream got %d attempts, want %d", attempts, tt.attempts)
+			}
+			if len(delays) != tt.waits {
+				t.Errorf("waited %d times, want %d", len(delays), tt.waits)
+			}
+			for i, want := range tt.delays {
+				if i < len(delays) && delays[i] != want {
+					t.Errorf("wait %d lasted %v, want %v", i+1, delays[i], want)
+				}
+			}
+			for _, delay := range delays {
+				if delay <= 0 || delay > 10*time.Second {
+					t.Errorf("wait of %v is outside of the policy's delays", delay)
+				}
+			}
+		})
+	}
+}
+
+func TestContextLengthErrorIsNotRetryable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Some servers report an overflowing prompt as a server error
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":{"type":"server_error","message":"prompt is too long"}}`)
+	}))
+	defer upstream.Close()
+
+	service := &OpenAIService{
+		APIURL: upstream.URL,
+		Client: upstream.Client(),
+		Retry:  RetryPolicy{MaxAttempts: 3, sleep: func(time.Duration) {}},
+	}
+	_, err := service.SendMessage(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{})
+	providerErr, ok := AsProviderError(err)
+	if !ok || !providerErr.IsContextLengthError() || providerErr.Retryable || providerErr.Attempts != 1 {
+		t.Fatalf("SendMessage error = %#v, want a context length error after one attempt", err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"chatbot_backend/repository"
+	"context"
+	"html"
+	"strings"
+	"time"
+)
+
+// SearchRequest asks for the messages and session titles of the user's
+// sessions matching Query. Sender, From and To only match messages, with To
+// exclusive; a limit of zero or less uses DefaultPageSize and larger limits
+// are capped at MaxPageSize.
+type SearchRequest struct {
+	Query         string
+	Sender        string // "user" or "bot", empty for both and for titles
+	From          *time.Time
+	To            *time.Time
+	FavoritesOnly bool
+	Limit         int
+}
+
+// SearchResult is a message or a session title matching a search
+type SearchResult struct {
+	SessionID    string    `json:"sessionId"`
+	SessionTitle string    `json:"sessionTitle"`
+	MessageID    string    `json:"messageId,omitempty"` // empty when the title matched
+	Sender       string    `json:"sender,omitempty"`
+	Snippet      string    `json:"snippet"` // HTML-escaped, with the matches in <mark> tags
+	Rank         float64   `json:"rank"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// Search returns the messages and session titles of the user's sessions
+// matching the request, best first
+func (s *ChatService) Search(ctx context.Context, userID string, request SearchRequest) ([]SearchResult, error) {
+	results := make([]SearchResult, 0)
+	text := strings.TrimSpace(request.Query)
+	if text == "" {
+		return results, nil
+	}
+
+	query := repository.SearchQuery{
+		Text:          text,
+		Sender:        request.Sender,
+		From:          request.From,
+		To:            request.To,
+		FavoritesOnly: request.FavoritesOnly,
+		Limit:         request.Limit,
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+
+	hits, err := s.search.Search(ctx, userID, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range hits {
+		results = append(results, SearchResult{
+			SessionID:    hit.SessionID,
+			SessionTitle: hit.SessionTitle,
+			MessageID:    hit.MessageID,
+			Sender:       hit.Sender,
+			Snippet:      markSnippet(hit.Snippet),
+			Rank:         hit.Rank,
+			Timestamp:    hit.Timestamp,
+		})
+	}
+	return results, nil
+}
+
+// snippetMarks turns the highlight markers of escaped snippets into tags
+var snippetMarks = strings.NewReplacer(repository.HighlightStart, "<mark>", repository.HighlightEnd, "</mark>")
+
+// markSnippet escapes a snippet for HTML and wraps its matches in <mark> tags
+func markSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
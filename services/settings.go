@@ -0,0 +1,165 @@
+package services
+
+import (
+	"chatbot_backend/models"
+	"fmt"
+)
+
+// Default sampling temperatures
+const (
+	DefaultTemperature    = 0.7
+	RegenerateTemperature = 0.8 // slightly higher for more variation
+)
+
+// Limits on user supplied generation settings
+const (
+	MaxStopSequences      = 4 // the number of stop sequences the providers accept
+	MaxSystemPromptLength = 8000
+)
+
+// GenerationSettings controls how the AI service generates a response.
+// Zero values mean the provider's defaults.
+type GenerationSettings struct {
+	SystemPrompt string
+	Model        string
+	Temperature  *float64
+	TopP         *float64
+	MaxTokens    int
+	Stop         []string
+}
+
+// ResolveSettings returns the provider name and generation settings of a
+// session. The session's own values override the defaults of its persona,
+// which must be loaded in session.Persona. The persona's model only applies
+// when the session uses the persona's provider.
+func ResolveSettings(session models.Session) (string, GenerationSettings) {
+	providerName := session.Provider
+	var settings GenerationSettings
+
+	if persona := session.Persona; persona != nil {
+		if providerName == "" {
+			providerName = persona.Provider
+		}
+		if providerName == persona.Provider {
+			settings.Model = persona.Model
+		}
+		settings.SystemPrompt = persona.SystemPrompt
+		settings.Temperature = persona.Temperature
+		settings.TopP = persona.TopP
+		settings.MaxTokens = persona.MaxTokens
+		settings.Stop = persona.StopSequences
+	}
+
+	if session.SystemPrompt != "" {
+		settings.SystemPrompt = session.SystemPrompt
+	}
+	if session.Model != "" {
+		settings.Model = session.Model
+	}
+	if session.Temperature != nil {
+		settings.Temperature = session.Temperature
+	}
+	if session.TopP != nil {
+		settings.TopP = session.TopP
+	}
+	if session.MaxTokens > 0 {
+		settings.MaxTokens = session.MaxTokens
+	}
+	if len(session.StopSequences) > 0 {
+		settings.Stop = session.StopSequences
+	}
+
+	return providerName, settings
+}
+
+// PersonaSettings returns the provider name and generation settings of a persona
+func PersonaSettings(persona models.Persona) (string, GenerationSettings) {
+	return ResolveSettings(models.Session{Persona: &persona})
+}
+
+// systemPrompt returns the configured system prompt or the default one.
+// Regenerations add the instruction to vary the answer.
+func (g GenerationSettings) systemPrompt(regenerate bool) string {
+	if g.SystemPrompt == "" {
+		if regenerate {
+			return RegenerateSystemPrompt
+		}
+		return DefaultSystemPrompt
+	}
+	if regenerate {
+		return g.SystemPrompt + "\n\n" + regenerateInstruction
+	}
+	return g.SystemPrompt
+}
+
+// model returns the configured model or the fallback
+func (g GenerationSettings) model(fallback string) string {
+	if g.Model != "" {
+		return g.Model
+	}
+	return fallback
+}
+
+// temperature returns the configured temperature or the fallback
+func (g GenerationSettings) temperature(fallback float64) *float64 {
+	if g.Temperature != nil {
+		return g.Temperature
+	}
+	return &fallback
+}
+
+// maxTokens returns the configured maximum tokens or the default
+func (g GenerationSettings) maxTokens() int {
+	if g.MaxTokens > 0 {
+		return g.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+// ValidateSettings checks generation settings against the provider's model
+// allow-list and the configured limits
+func (r *Registry) ValidateSettings(providerName string, settings GenerationSettings) error {
+	if providerName == "" {
+		providerName = r.defaultName
+	}
+	p, ok := r.providers[providerName]
+	if !ok {
+		return fmt.Errorf("unknown AI provider %q", providerName)
+	}
+
+	if settings.Model != "" && !contains(p.info.Models, settings.Model) {
+		return fmt.Errorf("model %q is not allowed for provider %q", settings.Model, providerName)
+	}
+	if t := settings.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p := settings.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("topP must be greater than 0 and at most 1")
+	}
+	if settings.MaxTokens < 0 || settings.MaxTokens > r.maxTokensLimit {
+		return fmt.Errorf("maxTokens must be between 0 (provider default) and %d", r.maxTokensLimit)
+	}
+	if len([]rune(settings.SystemPrompt)) > MaxSystemPromptLength {
+		return fmt.Errorf("system prompt must be at most %d characters", MaxSystemPromptLength)
+	}
+	if len(settings.Stop) > MaxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed", MaxStopSequences)
+	}
+	for _, stop := range settings.Stop {
+		if stop == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether the list contains the value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
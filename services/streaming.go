@@ -0,0 +1,175 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrStreamIncomplete is returned when a stream ends before the provider
+// marks the response as complete, such as when a proxy cuts it off
+var ErrStreamIncomplete = errors.New("stream ended before completion")
+
+// DeltaFunc receives each piece of a streamed response.
+// Returning an error stops the stream.
+type DeltaFunc func(delta string) error
+
+// StreamingAIService is implemented by AI services that can stream responses
+type StreamingAIService interface {
+	AIService
+	// StreamMessage streams the response to the conversation through onDelta
+	// and returns the full completion received, which is partial if an error
+	// occurred.
+	StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error)
+}
+
+// StreamChunk represents a chunk of a streamed OpenAI response
+type StreamChunk struct {
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"` // only in the last chunk
+	Error   *APIError      `json:"error,omitempty"`
+}
+
+// StreamChoice represents a choice in a streamed OpenAI response
+type StreamChoice struct {
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// newStreamClient returns an HTTP client for streamed responses. A total
+// timeout would cut off long generations, so it only limits the wait for the
+// response headers and leaves ending the stream to the request context.
+func newStreamClient(headerTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = headerTimeout
+	return &http.Client{Transport: transport}
+}
+
+// streamingClient returns the client for streamed requests, falling back to
+// the client of blocking requests if the service has none
+func streamingClient(stream, client *http.Client) *http.Client {
+	if stream != nil {
+		return stream
+	}
+	return client
+}
+
+// StreamMessage streams the response from the AI service or, if the service
+// cannot stream, sends the whole response as a single delta.
+func StreamMessage(ctx context.Context, aiService AIService, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	if streamer, ok := aiService.(StreamingAIService); ok {
+		return streamer.StreamMessage(ctx, history, settings, onDelta)
+	}
+
+	completion, err := aiService.SendMessage(ctx, history, settings)
+	if err != nil {
+		return Completion{}, err
+	}
+	if err := onDelta(completion.Content); err != nil {
+		return Completion{}, err
+	}
+	return completion, nil
+}
+
+// StreamMessage streams the response to the conversation from the OpenAI API
+func (s *OpenAIService) StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	request := s.buildRequest(settings.systemPrompt(false), history, settings, DefaultTemperature)
+	request.Stream = true
+	request.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+
+	resp, err := s.doRequest(ctx, request)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	completion := Completion{Model: request.Model}
+	result := func() Completion {
+		completion.Content = content.String()
+		return completion
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return result(), nil
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return result(), fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return result(), fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			completion.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			completion.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return result(), err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result(), fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return result(), ErrStreamIncomplete
+}
+
+// StreamMessage streams the mock response word by word
+func (m *MockAIService) StreamMessage(ctx context.Context, history []Message, settings GenerationSettings, onDelta DeltaFunc) (Completion, error) {
+	completion, err := m.SendMessage(ctx, history, settings)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	// SplitAfter keeps the separators so the deltas add up to the full response
+	var content strings.Builder
+	partial := func() Completion {
+		return m.complete(history, settings, content.String())
+	}
+	for _, word := range strings.SplitAfter(completion.Content, " ") {
+		if word == "" {
+			continue
+		}
+		if m.WordDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return partial(), ctx.Err()
+			case <-time.After(m.WordDelay):
+			}
+		}
+
+		content.WriteString(word)
+		if err := onDelta(word); err != nil {
+			return partial(), err
+		}
+	}
+
+	return completion, nil
+}
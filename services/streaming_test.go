@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStreamingUpstream returns an OpenAI service whose upstream answers with
+// the given server-sent event lines and records the request it received
+func newStreamingUpstream(t *testing.T, lines ...string) (*OpenAIService, *OpenAIRequest) {
+	t.Helper()
+
+	var request OpenAIRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s\n", line)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	service := &OpenAIService{
+		APIURL: upstream.URL,
+		Model:  "test-model",
+		Client: upstream.Client(),
+		Retry:  RetryPolicy{MaxAttempts: 1},
+	}
+	return service, &request
+}
+
+// collectDeltas returns a delta function that appends to the deltas
+func collectDeltas(deltas *[]string) DeltaFunc {
+	return func(delta string) error {
+		*deltas = append(*deltas, delta)
+		return nil
+	}
+}
+
+func TestOpenAIStreamMessage(t *testing.T) {
+	service, request := newStreamingUpstream(t,
+		": keep-alive",
+		`data: {"model":"test-model-0613","choices":[{"delta":{"role":"assistant"}}]}`,
+		"",
+		`data: {"choices":[{"delta":{"content":"Hello"}}]}`,
+		"",
+		`data:{"choices":[{"delta":{"content":", world"},"finish_reason":"stop"}]}`,
+		"",
+		// With include_usage the usage comes in a last chunk without choices
+		`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}`,
+		"",
+		"data: [DONE]",
+		"",
+		`data: {"choices":[{"delta":{"content":" after the end"}}]}`,
+	)
+
+	var deltas []string
+	completion, err := StreamMessage(context.Background(), service, []Message{{Role: RoleUser, Content: "Hi"}}, GenerationSettings{}, collectDeltas(&deltas))
+	if err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+
+	if !request.Stream || request.StreamOptions == nil || !request.StreamOptions.IncludeUsage {
+		t.Errorf("request stream %v with options %+v, want a stream that includes the usage", request.Stream, request.StreamOptions)
+	}
+	if got := strings.Join(deltas, "|"); got != "Hello|, world" {
+		t.Errorf("deltas = %q, want Hello and , world", got)
+	}
+	if completion.Content != "Hello, world" || completion.Model != "test-model-0613" {
+		t.Errorf("completion = %q from %q, want Hello, world from test-model-0613", completion.Content, completion.Model)
+	}
+	if completion.Usage != (Usage{PromptTokens: 12, CompletionTokens: 3}) {
+		t.Errorf("usage = %+v, want the usage of the last chunk", completion.Usage)
+	}
+}
+
+func TestOpenAIStreamMessageTruncated(t *testing.T) {
+	// The upstream cuts the stream off without the usage chunk or [DONE]
+	service, _ := newStreamingUpstream(t,
+		`data: {"choices":[{"delta":{"content":"Hello"}}]}`,
+		"",
+		`data: {"choices":[{"delta":{"content":", wor"}}]}`,
+	)
+
+	var deltas []string
+	completion, err := service.StreamMessage(context.Background(), nil, GenerationSettings{}, collectDeltas(&deltas))
+	if !errors.Is(err, ErrStreamIncomplete) {
+		t.Fatalf("StreamMessage error = %v, want ErrStreamIncomplete", err)
+	}
+	if completion.Content != "Hello, wor" || len(deltas) != 2 || completion.Model != "test-model" {
+		t.Errorf("partial completion = %q from %q after %d deltas, want the text received so far", completion.Content, completion.Model, len(deltas))
+	}
+}
+
+func TestOpenAIStreamMessageErrors(t *testing.T) {
+	hello := `data: {"choices":[{"delta":{"content":"Hello"}}]}`
+
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{
+			name:    "error chunk",
+			lines:   []string{hello, `data: {"error":{"type":"server_error","message":"The server had an error"}}`},
+			wantErr: "API error: The server had an error",
+		},
+		{
+			name:    "malformed chunk",
+			lines:   []string{hello, "data: {not json"},
+			wantErr: "failed to unmarshal stream chunk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newStreamingUpstream(t, tt.lines...)
+
+			var deltas []string
+			completion, err := service.StreamMessage(context.Background(), nil, GenerationSettings{}, collectDeltas(&deltas))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("StreamMessage error = %v, want %q", err, tt.wantErr)
+			}
+			if completion.Content != "Hello" || len(deltas) != 1 {
+				t.Errorf("partial completion = %q after %d deltas, want Hello", completion.Content, len(deltas))
+			}
+		})
+	}
+}
+
+func TestOpenAIStreamMessageStopsOnDeltaError(t *testing.T) {
+	service, _ := newStreamingUpstream(t,
+		`data: {"choices":[{"delta":{"content":"Hello"}}]}`,
+		`data: {"choices":[{"delta":{"content":", world"}}]}`,
+		"data: [DONE]",
+	)
+
+	stop := errors.New("client went away")
+	deltas := 0
+	completion, err := service.StreamMessage(context.Background(), nil, GenerationSettings{}, func(delta string) error {
+		deltas++
+		return stop
+	})
+	if !errors.Is(err, stop) || deltas != 1 {
+		t.Fatalf("StreamMessage = %v after %d deltas, want the delta error after the first", err, deltas)
+	}
+	if completion.Content != "Hello" {
+		t.Errorf("partial completion = %q, want the delta sent so far", completion.Content)
+	}
+}
+
+func TestOpenAIStreamOutlastsRequestTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"Hello"}}]}`)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":", world"}}]}`)
+		fmt.Fprintln(w, "data: [DONE]")
+	}))
+	defer upstream.Close()
+
+	// The stream takes longer than blocking requests are allowed to
+	service := &OpenAIService{
+		APIURL:       upstream.URL,
+		Client:       &http.Client{Timeout: 100 * time.Millisecond},
+		StreamClient: newStreamClient(time.Second),
+		Retry:        RetryPolicy{MaxAttempts: 1},
+	}
+	completion, err := service.StreamMessage(context.Background(), nil, GenerationSettings{}, func(string) error { return nil })
+	if err != nil || completion.Content != "Hello, world" {
+		t.Fatalf("StreamMessage = %q, %v; want the whole stream", completion.Content, err)
+	}
+
+	if _, err := service.SendMessage(context.Background(), nil, GenerationSettings{}); err == nil {
+		t.Error("SendMessage outlasted the request timeout")
+	}
+}
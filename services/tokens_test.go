@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessToken(t *testing.T) {
+	clock := newFakeClock()
+	tokens := NewTokenManager("secret", 15*time.Minute, 24*time.Hour)
+	tokens.now = clock.Now
+
+	token, expiresAt, err := tokens.IssueAccessToken("user-1", "admin")
+	if err != nil {
+		t.Fatalf("IssueAccessToken: %v", err)
+	}
+	if want := clock.Now().Add(15 * time.Minute); !expiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", expiresAt, want)
+	}
+
+	claims, err := tokens.ParseAccessToken(token)
+	if err != nil || claims.Subject != "user-1" || claims.Role != "admin" {
+		t.Fatalf("ParseAccessToken = %+v, %v; want the user and role", claims, err)
+	}
+
+	clock.Advance(15*time.Minute - time.Second)
+	if _, err := tokens.ParseAccessToken(token); err != nil {
+		t.Fatalf("ParseAccessToken before expiry: %v", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := tokens.ParseAccessToken(token); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("ParseAccessToken after expiry = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestAccessTokenSignature(t *testing.T) {
+	tokens := NewTokenManager("secret", time.Hour, time.Hour)
+	token, _, err := tokens.IssueAccessToken("user-1", "user")
+	if err != nil {
+		t.Fatalf("IssueAccessToken: %v", err)
+	}
+	parts := strings.Split(token, ".")
+
+	others := NewTokenManager("other secret", time.Hour, time.Hour)
+	forged, _, err := others.IssueAccessToken("user-1", "admin")
+	if err != nil {
+		t.Fatalf("IssueAccessToken: %v", err)
+	}
+
+	for name, token := range map[string]string{
+		"other secret":      forged,
+		"changed claims":    parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2],
+		"missing signature": parts[0] + "." + parts[1],
+		"garbage":           "not a token",
+	} {
+		if _, err := tokens.ParseAccessToken(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: ParseAccessToken = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	clock := newFakeClock()
+	tokens := NewTokenManager("secret", time.Minute, 30*24*time.Hour)
+	tokens.now = clock.Now
+
+	token, hash, expiresAt, err := tokens.NewRefreshToken()
+	if err != nil {
+		t.Fatalf("NewRefreshToken: %v", err)
+	}
+	if hash != HashToken(token) || hash == token {
+		t.Errorf("stored hash %q does not match the token", hash)
+	}
+	if want := clock.Now().Add(30 * 24 * time.Hour); !expiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", expiresAt, want)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"chatbot_backend/config"
+	"chatbot_backend/models"
+	"strings"
+)
+
+// Usage is the number of tokens a completion used, as reported by the provider
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// TotalTokens returns the number of prompt and completion tokens
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Completion is a response from an AI service along with the model that
+// produced it and the tokens it used
+type Completion struct {
+	Content string
+	Model   string
+	Usage   Usage
+	// Cost is filled in by the registry from its price table
+	Cost float64
+
+	// Usage and cost of the requests that prepared the prompt, such as
+	// summarizing older turns. Quotas count them; the reply's own usage
+	// does not include them.
+	OverheadUsage Usage
+	OverheadCost  float64
+}
+
+// PriceTable maps model names to their prices. A model without an exact
+// entry uses the longest entry it starts with, so "gpt-4o" also prices
+// dated versions such as "gpt-4o-2024-08-06".
+type PriceTable map[string]config.ModelPrice
+
+// Lookup returns the price of the model
+func (t PriceTable) Lookup(model string) (config.ModelPrice, bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+
+	match := ""
+	for name := range t {
+		if strings.HasPrefix(model, name) && len(name) > len(match) {
+			match = name
+		}
+	}
+	if match == "" {
+		return config.ModelPrice{}, false
+	}
+	return t[match], true
+}
+
+// Cost returns the cost in USD of the usage, or zero for unpriced models
+func (t PriceTable) Cost(model string, usage Usage) float64 {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+// SetUsage records the model, tokens and cost of a completion on a bot message
+func SetUsage(message *models.Message, completion Completion) {
+	message.Model = completion.Model
+	message.PromptTokens = completion.Usage.PromptTokens
+	message.CompletionTokens = completion.Usage.CompletionTokens
+	message.Cost = completion.Cost
+}